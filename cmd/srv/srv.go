@@ -1,21 +1,135 @@
 package main
 
 import (
+	"bytes"
+	"compress/gzip"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"flag"
 	"io"
 	"log"
 	"net/http"
 	"os"
+	"sync"
+	"time"
+
+	"github.com/sanchitrk/gosh"
 )
 
+// maxSkew is how far a signed request's timestamp may be from the server's
+// clock, and how long its nonce is remembered to reject replays.
+const maxSkew = 5 * time.Minute
+
+// defaultMaxBody is the default limit on the size of a signed request body,
+// well above the batches gosh sends.
+const defaultMaxBody = 8 << 20
+
+// nonceCache remembers recently seen nonces until they expire.
+type nonceCache struct {
+	mu        sync.Mutex
+	seen      map[string]time.Time
+	nextSweep time.Time
+}
+
+// add records nonce and reports whether it had not been seen before.
+// Expired nonces are swept at most once per maxSkew.
+func (c *nonceCache) add(nonce string, now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if now.After(c.nextSweep) {
+		for n, expiry := range c.seen {
+			if now.After(expiry) {
+				delete(c.seen, n)
+			}
+		}
+		c.nextSweep = now.Add(maxSkew)
+	}
+	if expiry, ok := c.seen[nonce]; ok && !now.After(expiry) {
+		return false
+	}
+	c.seen[nonce] = now.Add(2 * maxSkew)
+	return true
+}
+
+// verifyHMAC rejects requests that are not signed with secret, whose
+// timestamp is too far off, or whose nonce was already used. Bodies larger
+// than maxBody bytes are rejected before they are buffered to be verified.
+func verifyHMAC(secret []byte, maxBody int64, next http.Handler) http.Handler {
+	nonces := &nonceCache{seen: make(map[string]time.Time)}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBody))
+		if err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+				return
+			}
+			http.Error(w, "failed to read body", http.StatusBadRequest)
+			return
+		}
+
+		now := time.Now()
+		if err := gosh.VerifyHMAC(secret, r.Header, body, now, maxSkew); err != nil {
+			log.Printf("Rejected request: %v", err)
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		if !nonces.add(r.Header.Get(gosh.NonceHeader), now) {
+			log.Println("Rejected replayed request")
+			http.Error(w, "replayed request", http.StatusUnauthorized)
+			return
+		}
+
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		next.ServeHTTP(w, r)
+	})
+}
+
+// decompress transparently decodes request bodies sent with a supported
+// Content-Encoding, and rejects bodies in encodings it cannot decode.
+func decompress(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Header.Get("Content-Encoding") {
+		case "", "identity":
+		case "gzip":
+			zr, err := gzip.NewReader(r.Body)
+			if err != nil {
+				http.Error(w, "invalid gzip body", http.StatusBadRequest)
+				return
+			}
+			defer zr.Close()
+			r.Body = zr
+			r.Header.Del("Content-Encoding")
+		default:
+			http.Error(w, "unsupported content encoding", http.StatusUnsupportedMediaType)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
 func main() {
-	http.HandleFunc("/logs", func(w http.ResponseWriter, r *http.Request) {
+	addr := flag.String("addr", ":8080", "address to listen on")
+	hmacSecret := flag.String("hmac-secret", os.Getenv("GOSH_HMAC_SECRET"),
+		"require requests signed with this HMAC secret (default $GOSH_HMAC_SECRET)")
+	maxBody := flag.Int64("max-body", defaultMaxBody, "largest signed request body accepted, in bytes")
+	tlsCert := flag.String("tls-cert", "", "serve HTTPS with this PEM certificate")
+	tlsKey := flag.String("tls-key", "", "PEM key for -tls-cert")
+	clientCA := flag.String("client-ca", "", "require client certificates signed by this PEM CA bundle (mTLS)")
+	flag.Parse()
+
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/logs", func(w http.ResponseWriter, r *http.Request) {
 		log.Println("Received log stream...")
 		// Copy the request body (the logs) to the server's stdout.
 		io.Copy(os.Stdout, r.Body)
 		w.WriteHeader(http.StatusOK)
 	})
 
-	http.HandleFunc("/logs/auth", func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("/logs/auth", func(w http.ResponseWriter, r *http.Request) {
 		log.Println("Received log stream on /logs/auth...")
 		token := r.Header.Get("Token")
 		log.Printf("Token: %s\n", token)
@@ -24,6 +138,39 @@ func main() {
 		w.WriteHeader(http.StatusOK)
 	})
 
-	log.Println("Log ingestor server starting on :8080")
-	log.Fatal(http.ListenAndServe(":8080", nil))
+	// Signatures cover the body as sent, so they are checked before decoding
+	handler := decompress(mux)
+	if *hmacSecret != "" {
+		handler = verifyHMAC([]byte(*hmacSecret), *maxBody, handler)
+		log.Println("Requiring HMAC-signed requests")
+	}
+
+	server := &http.Server{Addr: *addr, Handler: handler}
+
+	if *clientCA != "" {
+		pem, err := os.ReadFile(*clientCA)
+		if err != nil {
+			log.Fatalf("Failed to read client CA bundle: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			log.Fatalf("No certificates found in client CA bundle %s", *clientCA)
+		}
+		server.TLSConfig = &tls.Config{
+			ClientCAs:  pool,
+			ClientAuth: tls.RequireAndVerifyClientCert,
+			MinVersion: tls.VersionTLS12,
+		}
+		log.Println("Requiring client certificates")
+	}
+
+	if *tlsCert != "" {
+		log.Printf("Log ingestor server starting on %s (HTTPS)", *addr)
+		log.Fatal(server.ListenAndServeTLS(*tlsCert, *tlsKey))
+	}
+	if *clientCA != "" {
+		log.Fatal("-client-ca requires -tls-cert and -tls-key")
+	}
+	log.Printf("Log ingestor server starting on %s", *addr)
+	log.Fatal(server.ListenAndServe())
 }
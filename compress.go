@@ -0,0 +1,68 @@
+package gosh
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+)
+
+// Compression selects the Content-Encoding used for HTTP stream batches.
+type Compression string
+
+const (
+	// CompressionNone sends batches uncompressed.
+	CompressionNone Compression = ""
+	// CompressionGzip sends batches with Content-Encoding: gzip.
+	CompressionGzip Compression = "gzip"
+)
+
+// DefaultCompressionMinBytes is the batch size below which compression is
+// skipped, since tiny bodies gain little and cost CPU.
+const DefaultCompressionMinBytes = 1024
+
+// WithCompression compresses batch bodies of at least minBytes with the
+// given encoding. Use DefaultCompressionMinBytes unless you have a reason
+// not to.
+func WithCompression(c Compression, minBytes int) HTTPStreamOption {
+	return func(w *HTTPStreamWriter) {
+		w.compression = c
+		w.compressMin = minBytes
+	}
+}
+
+// payload is a request body ready to be posted.
+type payload struct {
+	batch           []byte // the NDJSON lines the body was prepared from
+	body            []byte
+	contentType     string
+	contentEncoding string
+}
+
+// prepare turns a batch body into a payload, encoding it with the batch
+// encoder, if any, and compressing it if configured and the body is large
+// enough.
+func (w *HTTPStreamWriter) prepare(body []byte) (payload, error) {
+	p := payload{batch: body, body: body, contentType: "application/x-ndjson"}
+	if w.encoder != nil {
+		var err error
+		p.body, p.contentType, err = w.encoder.Encode(body)
+		if err != nil {
+			return payload{}, fmt.Errorf("%w: %w", ErrEncoding, err)
+		}
+	}
+	if w.compression != CompressionGzip || len(p.body) < w.compressMin {
+		return p, nil
+	}
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(p.body); err != nil {
+		return payload{}, err
+	}
+	if err := zw.Close(); err != nil {
+		return payload{}, err
+	}
+	p.body = buf.Bytes()
+	p.contentEncoding = string(CompressionGzip)
+	return p, nil
+}
@@ -0,0 +1,56 @@
+package gosh
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestHTTPStreamWriterGzip(t *testing.T) {
+	var mu sync.Mutex
+	var encodings []string
+	var received strings.Builder
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body io.Reader = r.Body
+		if r.Header.Get("Content-Encoding") == "gzip" {
+			zr, err := gzip.NewReader(r.Body)
+			if err != nil {
+				t.Errorf("invalid gzip body: %v", err)
+				return
+			}
+			body = zr
+		}
+		data, _ := io.ReadAll(body)
+		mu.Lock()
+		encodings = append(encodings, r.Header.Get("Content-Encoding"))
+		received.Write(data)
+		mu.Unlock()
+	}))
+	defer srv.Close()
+
+	w := NewHTTPStreamWriter(srv.URL, nil, WithCompression(CompressionGzip, 256))
+
+	// Below the threshold: sent as is
+	io.WriteString(w, "{\"msg\":\"small\"}\n")
+	w.Flush()
+
+	// Above the threshold: compressed
+	for i := 0; i < 50; i++ {
+		fmt.Fprintf(w, "{\"msg\":\"Step %d/50 : RUN apt-get install -y build-essential\"}\n", i)
+	}
+	w.Close()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(encodings) != 2 || encodings[0] != "" || encodings[1] != "gzip" {
+		t.Errorf("expected an uncompressed then a gzip batch, got encodings %q", encodings)
+	}
+	if got := strings.Count(received.String(), "\n"); got != 51 {
+		t.Errorf("expected 51 lines after decoding, got %d", got)
+	}
+}
@@ -0,0 +1,112 @@
+package gosh
+
+import (
+	"fmt"
+	"slices"
+)
+
+// maxCloseErrors bounds how many distinct errors, by message, a
+// DeliveryError keeps.
+const maxCloseErrors = 10
+
+// WithErrorHandler sets a function called for every delivery problem:
+// batches that failed after retries, spool errors and spool evictions.
+// It is called from the background sender, so it must not block for long
+// or write to the HTTPStreamWriter.
+func WithErrorHandler(fn func(error)) HTTPStreamOption {
+	return func(w *HTTPStreamWriter) {
+		w.onError = fn
+	}
+}
+
+// HTTPStreamStats is a snapshot of an HTTPStreamWriter's counters.
+type HTTPStreamStats struct {
+	// Sent is the number of lines delivered to the endpoint.
+	Sent uint64
+	// Failed is the number of lines that could not be delivered.
+	Failed uint64
+	// Retried is the number of delivery attempts that were retries.
+	Retried uint64
+	// Dropped is the number of lines discarded by the overflow policy or
+	// evicted from the spool.
+	Dropped uint64
+	// QueuedBytes is the size of the lines waiting to be sent.
+	QueuedBytes int
+	// InFlight is the number of HTTP requests currently in progress.
+	InFlight int
+	// LastError is the most recent delivery error, or nil.
+	LastError error
+}
+
+// Stats returns a snapshot of the writer's counters.
+func (w *HTTPStreamWriter) Stats() HTTPStreamStats {
+	w.mutex.Lock()
+	queued := w.queued
+	w.mutex.Unlock()
+
+	w.errMutex.Lock()
+	lastErr := w.lastErr
+	w.errMutex.Unlock()
+
+	return HTTPStreamStats{
+		Sent:        w.sent.Load(),
+		Failed:      w.failed.Load(),
+		Retried:     w.retried.Load(),
+		Dropped:     w.dropped.Load(),
+		QueuedBytes: queued,
+		InFlight:    int(w.inFlight.Load()),
+		LastError:   lastErr,
+	}
+}
+
+// DeliveryError is returned by HTTPStreamWriter.Close when lines written
+// since the previous Close could not be delivered.
+type DeliveryError struct {
+	Failed  uint64
+	Dropped uint64
+	// Errs holds the first distinct errors behind the failures.
+	Errs []error
+}
+
+func (e *DeliveryError) Error() string {
+	msg := fmt.Sprintf("HTTP log stream incomplete: %d lines failed, %d lines dropped", e.Failed, e.Dropped)
+	if len(e.Errs) > 0 {
+		msg += ": " + e.Errs[0].Error()
+	}
+	return msg
+}
+
+func (e *DeliveryError) Unwrap() []error { return e.Errs }
+
+// reportError records a delivery error and passes it to the error handler.
+func (w *HTTPStreamWriter) reportError(err error) {
+	w.errMutex.Lock()
+	w.lastErr = err
+	if len(w.closeErrs) < maxCloseErrors && !slices.ContainsFunc(w.closeErrs, func(e error) bool {
+		return e.Error() == err.Error()
+	}) {
+		w.closeErrs = append(w.closeErrs, err)
+	}
+	w.errMutex.Unlock()
+
+	if w.onError != nil {
+		w.onError(err)
+	}
+}
+
+// deliveryError returns a *DeliveryError for the failures since the
+// previous call, or nil if there were none.
+func (w *HTTPStreamWriter) deliveryError() error {
+	w.errMutex.Lock()
+	defer w.errMutex.Unlock()
+
+	failed, dropped := w.failed.Load(), w.dropped.Load()
+	errs := w.closeErrs
+	newFailed, newDropped := failed-w.closedFail, dropped-w.closedDrop
+	w.closedFail, w.closedDrop, w.closeErrs = failed, dropped, nil
+
+	if newFailed == 0 && newDropped == 0 {
+		return nil
+	}
+	return &DeliveryError{Failed: newFailed, Dropped: newDropped, Errs: errs}
+}
@@ -0,0 +1,120 @@
+package gosh
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestHTTPStreamWriterErrorHandler(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer srv.Close()
+
+	var mu sync.Mutex
+	var handled []error
+	w := NewHTTPStreamWriter(srv.URL, nil, WithErrorHandler(func(err error) {
+		mu.Lock()
+		handled = append(handled, err)
+		mu.Unlock()
+	}))
+
+	var closeErr error
+	stdout := captureOutput(func() {
+		io.WriteString(w, "{\"msg\":\"one\"}\n{\"msg\":\"two\"}\n")
+		closeErr = w.Close()
+	})
+
+	if stdout != "" {
+		t.Errorf("expected nothing to be printed to stdout, got %q", stdout)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	var statusErr *HTTPStatusError
+	if len(handled) != 1 || !errors.As(handled[0], &statusErr) || statusErr.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected one 401 error to be handled, got %v", handled)
+	}
+
+	stats := w.Stats()
+	if stats.Failed != 2 || stats.Sent != 0 || stats.LastError == nil {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+
+	var deliveryErr *DeliveryError
+	if !errors.As(closeErr, &deliveryErr) || deliveryErr.Failed != 2 {
+		t.Fatalf("expected Close to report 2 failed lines, got %v", closeErr)
+	}
+	if !errors.As(closeErr, &statusErr) {
+		t.Error("expected the underlying status error to be reachable from the delivery error")
+	}
+	if err := w.Close(); err != nil {
+		t.Errorf("expected a second Close without new failures to succeed, got %v", err)
+	}
+}
+
+func TestHTTPStreamWriterStatsRetried(t *testing.T) {
+	var attempts atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if attempts.Add(1) <= 2 {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer srv.Close()
+
+	w := NewHTTPStreamWriter(srv.URL, nil, WithRetryPolicy(fastRetry))
+	io.WriteString(w, "{\"msg\":\"one\"}\n")
+	if err := w.Close(); err != nil {
+		t.Fatalf("expected delivery to succeed after retries, got %v", err)
+	}
+
+	stats := w.Stats()
+	if stats.Sent != 1 || stats.Retried != 2 || stats.Failed != 0 || stats.LastError != nil {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+func TestShellDeliveryErr(t *testing.T) {
+	ConfigureGlobals()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer srv.Close()
+
+	shell := New().WithHTTPStreamOnly(srv.URL).Args("echo", "hello")
+	if _, err := shell.Exec(); err != nil {
+		t.Fatalf("expected command to succeed, but it failed: %v", err)
+	}
+
+	var deliveryErr *DeliveryError
+	if !errors.As(shell.DeliveryErr(), &deliveryErr) {
+		t.Fatalf("expected a delivery error, got %v", shell.DeliveryErr())
+	}
+}
+
+func TestDeliveryErrorKeepsDistinctErrors(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer srv.Close()
+
+	w := NewHTTPStreamWriter(srv.URL, nil)
+	for i := 0; i < 3; i++ {
+		io.WriteString(w, "{\"msg\":\"again\"}\n")
+		w.Flush()
+	}
+
+	var deliveryErr *DeliveryError
+	if err := w.Close(); !errors.As(err, &deliveryErr) || deliveryErr.Failed != 3 {
+		t.Fatalf("expected Close to report 3 failed lines, got %v", err)
+	}
+	if len(deliveryErr.Errs) != 1 {
+		t.Errorf("expected the repeated error once, got %v", deliveryErr.Errs)
+	}
+}
@@ -0,0 +1,160 @@
+package gosh
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// DefaultElasticsearchIndex is the index name template used when none is given.
+const DefaultElasticsearchIndex = "gosh-logs-{yyyy.MM.dd}"
+
+// ElasticsearchOptions describes how log lines are indexed.
+type ElasticsearchOptions struct {
+	// Index is the index or data stream name. Date patterns in braces, such
+	// as {yyyy.MM.dd}, are replaced with the UTC date of each line, using
+	// yyyy, yy, MM, dd and HH for the year, month, day and hour.
+	// DefaultElasticsearchIndex if empty.
+	Index string
+}
+
+// NewElasticsearchSink returns an HTTPStreamWriter that indexes log lines
+// through the _bulk API of the Elasticsearch or OpenSearch cluster at url,
+// with the usual batching, retries and spooling; streamOpts tune them.
+// Every line becomes a document with an "@timestamp" field; lines that are
+// not JSON are indexed as {"message": line}.
+//
+// The bulk response is checked item by item. Documents rejected with 429
+// or a 5xx status are retried on their own, and documents rejected for good,
+// such as mapping errors, are counted as failed and reported as
+// *BulkItemError values. Credentials can be passed with WithTokenProvider,
+// using a Token of type "ApiKey" for API keys.
+func NewElasticsearchSink(url string, opts ElasticsearchOptions, streamOpts ...HTTPStreamOption) *HTTPStreamWriter {
+	if opts.Index == "" {
+		opts.Index = DefaultElasticsearchIndex
+	}
+	enc := &bulkEncoder{index: opts.Index}
+	return NewHTTPStreamWriter(strings.TrimSuffix(url, "/")+"/_bulk", nil,
+		append([]HTTPStreamOption{WithBatchEncoder(enc)}, streamOpts...)...)
+}
+
+// BulkItemError is a document rejected in a bulk response.
+type BulkItemError struct {
+	Index  string
+	Status int
+	Type   string
+	Reason string
+}
+
+func (e *BulkItemError) Error() string {
+	return fmt.Sprintf("indexing into %s: status %d: %s: %s", e.Index, e.Status, e.Type, e.Reason)
+}
+
+// Unwrap returns the item status as an *HTTPStatusError, which decides
+// whether the document is retried.
+func (e *BulkItemError) Unwrap() error {
+	return &HTTPStatusError{StatusCode: e.Status, Status: fmt.Sprintf("%d %s", e.Status, http.StatusText(e.Status))}
+}
+
+// bulkEncoder is the BatchEncoder of NewElasticsearchSink.
+type bulkEncoder struct {
+	index string
+}
+
+// Encode implements BatchEncoder.
+func (e *bulkEncoder) Encode(batch []byte) ([]byte, string, error) {
+	var body bytes.Buffer
+	for _, line := range batchLines(batch) {
+		fields, isJSON := parseRecord(line)
+		t, ok := recordTime(fields)
+		if !ok {
+			t = time.Now()
+		}
+
+		// "create" works for both indices and data streams
+		action, _ := json.Marshal(map[string]map[string]string{
+			"create": {"_index": expandIndex(e.index, t)},
+		})
+		body.Write(action)
+		body.WriteByte('\n')
+
+		timestamp, _ := json.Marshal(t.UTC().Format(time.RFC3339Nano))
+		switch {
+		case !isJSON:
+			message, _ := json.Marshal(string(line))
+			fmt.Fprintf(&body, `{"@timestamp":%s,"message":%s}`, timestamp, message)
+		case fields["@timestamp"] != nil:
+			body.Write(line)
+		default:
+			body.WriteString(`{"@timestamp":`)
+			body.Write(timestamp)
+			if len(fields) > 0 {
+				body.WriteByte(',')
+			}
+			// Splice the field into the object, after its opening brace
+			body.Write(bytes.TrimSpace(line)[1:])
+		}
+		body.WriteByte('\n')
+	}
+	return body.Bytes(), "application/x-ndjson", nil
+}
+
+// CheckResponse implements BatchResponseChecker.
+func (e *bulkEncoder) CheckResponse(batch, resp []byte) []RejectedLine {
+	var result struct {
+		Errors bool `json:"errors"`
+		Items  []map[string]struct {
+			Index  string `json:"_index"`
+			Status int    `json:"status"`
+			Error  struct {
+				Type   string `json:"type"`
+				Reason string `json:"reason"`
+			} `json:"error"`
+		} `json:"items"`
+	}
+	if err := json.Unmarshal(resp, &result); err != nil || !result.Errors {
+		return nil
+	}
+	lines := batchLines(batch)
+	if len(result.Items) != len(lines) {
+		// Items cannot be matched to lines; retrying could duplicate documents
+		return nil
+	}
+
+	var rejected []RejectedLine
+	for i, item := range result.Items {
+		for _, r := range item {
+			if r.Status < 300 {
+				continue
+			}
+			rejected = append(rejected, RejectedLine{
+				Line: lines[i],
+				Err:  &BulkItemError{Index: r.Index, Status: r.Status, Type: r.Error.Type, Reason: r.Error.Reason},
+			})
+		}
+	}
+	return rejected
+}
+
+// indexDateLayout maps the date patterns of index templates to Go layouts.
+var indexDateLayout = strings.NewReplacer("yyyy", "2006", "yy", "06", "MM", "01", "dd", "02", "HH", "15")
+
+// expandIndex replaces the date patterns in braces in an index template
+// with t in UTC.
+func expandIndex(template string, t time.Time) string {
+	var b strings.Builder
+	for {
+		start := strings.IndexByte(template, '{')
+		end := strings.IndexByte(template[start+1:], '}')
+		if start < 0 || end < 0 {
+			b.WriteString(template)
+			return b.String()
+		}
+		b.WriteString(template[:start])
+		b.WriteString(t.UTC().Format(indexDateLayout.Replace(template[start+1 : start+1+end])))
+		template = template[start+end+2:]
+	}
+}
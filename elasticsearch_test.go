@@ -0,0 +1,195 @@
+package gosh
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// bulkDoc is a document received by the fake bulk endpoint.
+type bulkDoc struct {
+	index string
+	doc   map[string]any
+}
+
+// newBulkServer starts a stand-in for the _bulk API. The status for each
+// document is chosen by reject, called with its "msg" field and how many
+// times that message has been seen; 0 accepts the document.
+func newBulkServer(t *testing.T, reject func(msg string, attempt int) int) (*httptest.Server, func() [][]bulkDoc) {
+	t.Helper()
+	var mu sync.Mutex
+	var requests [][]bulkDoc
+	attempts := make(map[string]int)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/_bulk" || r.Header.Get("Content-Type") != "application/x-ndjson" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		if !bytes.HasSuffix(body, []byte("\n")) {
+			t.Errorf("bulk body must end with a newline")
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		var docs []bulkDoc
+		var items []map[string]any
+		hasErrors := false
+		scanner := bufio.NewScanner(bytes.NewReader(body))
+		for scanner.Scan() {
+			var action map[string]map[string]string
+			if err := json.Unmarshal(scanner.Bytes(), &action); err != nil || action["create"] == nil {
+				t.Errorf("expected a create action, got %q", scanner.Text())
+				return
+			}
+			if !scanner.Scan() {
+				t.Errorf("action without a document")
+				return
+			}
+			var doc map[string]any
+			if err := json.Unmarshal(scanner.Bytes(), &doc); err != nil {
+				t.Errorf("invalid document %q: %v", scanner.Text(), err)
+				return
+			}
+			index := action["create"]["_index"]
+			docs = append(docs, bulkDoc{index: index, doc: doc})
+
+			msg, _ := doc["msg"].(string)
+			attempts[msg]++
+			item := map[string]any{"_index": index, "status": 201}
+			if status := reject(msg, attempts[msg]); status != 0 {
+				hasErrors = true
+				item["status"] = status
+				item["error"] = map[string]string{"type": "rejected", "reason": fmt.Sprintf("rejected %q", msg)}
+			}
+			items = append(items, map[string]any{"create": item})
+		}
+		requests = append(requests, docs)
+		json.NewEncoder(w).Encode(map[string]any{"took": 1, "errors": hasErrors, "items": items})
+	}))
+	t.Cleanup(srv.Close)
+	return srv, func() [][]bulkDoc {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([][]bulkDoc(nil), requests...)
+	}
+}
+
+func TestElasticsearchSinkRetriesOnlyFailedDocuments(t *testing.T) {
+	ConfigureGlobals()
+
+	srv, requests := newBulkServer(t, func(msg string, attempt int) int {
+		switch {
+		case msg == "throttled" && attempt == 1:
+			return http.StatusTooManyRequests
+		case msg == "bad mapping":
+			return http.StatusBadRequest
+		}
+		return 0
+	})
+
+	var handled []error
+	w := NewElasticsearchSink(srv.URL+"/", ElasticsearchOptions{}, WithRetryPolicy(fastRetry), WithErrorHandler(func(err error) {
+		handled = append(handled, err)
+	}))
+	io.WriteString(w, "{\"timestamp\":1700000000,\"msg\":\"ok\"}\n")
+	io.WriteString(w, "{\"timestamp\":1700000000,\"msg\":\"throttled\"}\n")
+	io.WriteString(w, "{\"timestamp\":1700000000,\"msg\":\"bad mapping\"}\n")
+	io.WriteString(w, "plain text\n")
+	w.Close()
+
+	reqs := requests()
+	if len(reqs) != 2 {
+		t.Fatalf("expected 2 bulk requests, got %d", len(reqs))
+	}
+	if len(reqs[0]) != 4 {
+		t.Errorf("expected 4 documents in the first request, got %d", len(reqs[0]))
+	}
+	if len(reqs[1]) != 1 || reqs[1][0].doc["msg"] != "throttled" {
+		t.Errorf("expected only the throttled document to be retried, got %v", reqs[1])
+	}
+
+	first := reqs[0]
+	if first[0].index != "gosh-logs-2023.11.14" {
+		t.Errorf("expected the index to be named after the line's date, got %q", first[0].index)
+	}
+	if first[0].doc["@timestamp"] != "2023-11-14T22:13:20Z" || first[0].doc["seq"] == nil {
+		t.Errorf("expected @timestamp and seq on the document, got %v", first[0].doc)
+	}
+	if first[3].doc["message"] != "plain text" {
+		t.Errorf("expected a plain line to be wrapped in a document, got %v", first[3].doc)
+	}
+
+	stats := w.Stats()
+	if stats.Sent != 3 || stats.Failed != 1 || stats.Retried != 1 {
+		t.Errorf("expected 3 sent, 1 failed and 1 retry, got %+v", stats)
+	}
+	var itemErr *BulkItemError
+	if len(handled) != 1 || !errors.As(handled[0], &itemErr) || itemErr.Status != http.StatusBadRequest {
+		t.Errorf("expected the mapping error to be reported as a *BulkItemError, got %v", handled)
+	}
+}
+
+func TestExpandIndex(t *testing.T) {
+	ts := time.Date(2025, 3, 7, 21, 30, 0, 0, time.FixedZone("X", -5*3600))
+	tests := []struct {
+		template string
+		want     string
+	}{
+		{"gosh-logs-{yyyy.MM.dd}", "gosh-logs-2025.03.08"},
+		{"logs-{yy}-{MM}-{dd}-{HH}", "logs-25-03-08-02"},
+		{"static", "static"},
+		{"broken-{yyyy", "broken-{yyyy"},
+	}
+	for _, tt := range tests {
+		if got := expandIndex(tt.template, ts); got != tt.want {
+			t.Errorf("expandIndex(%q) = %q, want %q", tt.template, got, tt.want)
+		}
+	}
+}
+
+func TestElasticsearchSinkSpoolKeepsOnlyFailedDocuments(t *testing.T) {
+	var throttle sync.Mutex
+	throttled := true
+	srv, requests := newBulkServer(t, func(msg string, attempt int) int {
+		throttle.Lock()
+		defer throttle.Unlock()
+		if msg == "throttled" && throttled {
+			return http.StatusTooManyRequests
+		}
+		return 0
+	})
+
+	dir := t.TempDir()
+	w := NewElasticsearchSink(srv.URL, ElasticsearchOptions{Index: "builds"}, WithRetryPolicy(noRetry), WithSpool(dir, 1<<20, SpoolDropOldest))
+	io.WriteString(w, "{\"msg\":\"ok\"}\n{\"msg\":\"throttled\"}\n")
+	w.Flush()
+
+	throttle.Lock()
+	throttled = false
+	throttle.Unlock()
+	deadline := time.Now().Add(5 * time.Second)
+	for w.Stats().Sent < 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	w.Close()
+
+	var msgs []string
+	for _, req := range requests() {
+		for _, d := range req {
+			msgs = append(msgs, d.doc["msg"].(string))
+		}
+	}
+	if strings.Join(msgs, ",") != "ok,throttled,throttled" {
+		t.Errorf("expected only the throttled document to be spooled and replayed, got %q", msgs)
+	}
+}
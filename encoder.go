@@ -0,0 +1,131 @@
+package gosh
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// BatchEncoder converts batches of log lines into the request body expected
+// by an endpoint that does not accept NDJSON, such as Loki's push API.
+//
+// Batches are encoded when they are sent, after the "seq" field has been
+// added, and again when spooled batches are replayed, so the spool always
+// holds plain NDJSON. Encode may be called from the sender and the spool
+// replayer concurrently.
+type BatchEncoder interface {
+	// Encode returns the body for batch, one or more newline-terminated
+	// lines, and its Content-Type. Lines that are not JSON must be kept
+	// rather than dropped. An error fails the batch without retries.
+	Encode(batch []byte) (body []byte, contentType string, err error)
+}
+
+// ErrEncoding wraps errors returned by a BatchEncoder. Batches that fail to
+// encode are not retried or spooled, since they would fail again.
+var ErrEncoding = errors.New("encoding batch")
+
+// WithBatchEncoder makes the writer send batches encoded by enc instead of
+// as NDJSON. Compression, signing, retries and spooling apply as usual, to
+// the encoded body.
+func WithBatchEncoder(enc BatchEncoder) HTTPStreamOption {
+	return func(w *HTTPStreamWriter) {
+		w.encoder = enc
+	}
+}
+
+// setLogKVs passes the log KVs of the Shell writing to w to its encoder, if
+// the encoder uses them.
+func (w *HTTPStreamWriter) setLogKVs(kvs map[string]string) {
+	if enc, ok := w.encoder.(logKVsSink); ok {
+		enc.setLogKVs(kvs)
+	}
+}
+
+// BatchResponseChecker is implemented by BatchEncoders whose endpoint
+// accepts a batch as a whole but may reject some of its lines, as the
+// Elasticsearch _bulk API does. Rejected lines whose error is retryable,
+// such as an *HTTPStatusError with status 429, are sent again on their own;
+// the others are counted as failed.
+type BatchResponseChecker interface {
+	// CheckResponse returns the lines of batch that the endpoint rejected
+	// according to resp, the body of a successful response.
+	CheckResponse(batch, resp []byte) []RejectedLine
+}
+
+// RejectedLine is a line of a batch that the endpoint did not accept.
+type RejectedLine struct {
+	// Line is the rejected line, without its trailing newline.
+	Line []byte
+	Err  error
+}
+
+// parseRecord decodes a JSON log line into its fields, keeping numbers as
+// json.Number so they are passed on unchanged. It reports false for lines
+// that are not JSON objects.
+func parseRecord(line []byte) (map[string]any, bool) {
+	dec := json.NewDecoder(bytes.NewReader(line))
+	dec.UseNumber()
+	var fields map[string]any
+	if dec.Decode(&fields) != nil || fields == nil {
+		return nil, false
+	}
+	return fields, true
+}
+
+// recordTime returns the zerolog timestamp of a record, interpreted with
+// the configured zerolog.TimeFieldFormat, or false if it has none.
+func recordTime(fields map[string]any) (time.Time, bool) {
+	switch v := fields[zerolog.TimestampFieldName].(type) {
+	case json.Number:
+		n, err := v.Int64()
+		if err != nil {
+			return time.Time{}, false
+		}
+		switch zerolog.TimeFieldFormat {
+		case zerolog.TimeFormatUnixMs:
+			return time.UnixMilli(n), true
+		case zerolog.TimeFormatUnixMicro:
+			return time.UnixMicro(n), true
+		case zerolog.TimeFormatUnixNano:
+			return time.Unix(0, n), true
+		}
+		return time.Unix(n, 0), true
+	case string:
+		if t, err := time.Parse(zerolog.TimeFieldFormat, v); err == nil {
+			return t, true
+		}
+		if t, err := time.Parse(time.RFC3339Nano, v); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// fieldString renders a record field as a string: strings and numbers as
+// they are, anything else as JSON.
+func fieldString(v any) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	case nil:
+		return ""
+	}
+	b, _ := json.Marshal(v)
+	return string(b)
+}
+
+// batchLines returns the non-empty lines of a batch, without their newlines.
+func batchLines(batch []byte) [][]byte {
+	var lines [][]byte
+	for _, line := range bytes.Split(batch, []byte{'\n'}) {
+		if len(line) > 0 {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
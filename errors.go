@@ -0,0 +1,138 @@
+package gosh
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrTimeout matches errors for commands that ran longer than their Timeout.
+	ErrTimeout = errors.New("command timed out")
+	// ErrCancelled matches errors for commands stopped because their context was done.
+	ErrCancelled = errors.New("command cancelled")
+	// ErrNotFound matches errors for commands whose executable or working
+	// directory does not exist.
+	ErrNotFound = errors.New("command not found")
+)
+
+// DefaultStderrTailLines is how many trailing stderr lines an ExitError
+// carries, unless changed with StderrTail().
+const DefaultStderrTailLines = 10
+
+// ExitError is returned by Exec, Stream and their variants when a command
+// fails, fails to start, times out or is cancelled. It describes what ran so
+// the error can be shown to users as is. Use errors.Is with ErrTimeout,
+// ErrCancelled or ErrNotFound to classify it, and errors.As to reach the
+// underlying *exec.ExitError or *TimeoutError.
+type ExitError struct {
+	// Command is the command line with secrets masked.
+	Command string
+	Dir     string
+	// ExitCode is the exit code of the command, or -1 if it did not start
+	// or was terminated by a signal.
+	ExitCode int
+	// Stderr holds the last lines the command wrote to stderr, with secrets masked.
+	Stderr []string
+	LogKVs map[string]string
+	Err    error
+}
+
+func (e *ExitError) Error() string {
+	var b strings.Builder
+	b.WriteString(e.Command)
+	if e.Dir != "" {
+		fmt.Fprintf(&b, " (in %s)", e.Dir)
+	}
+	fmt.Fprintf(&b, ": %v", e.Err)
+	for _, line := range e.Stderr {
+		b.WriteString("\n")
+		b.WriteString(line)
+	}
+	return b.String()
+}
+
+func (e *ExitError) Unwrap() error { return e.Err }
+
+// TimeoutError is returned when a command runs longer than its Timeout.
+// It matches ErrTimeout with errors.Is.
+type TimeoutError struct {
+	Timeout time.Duration
+	Outcome Outcome // OutcomeTerminated or OutcomeKilled
+	Err     error   // error returned by the process wait, if any
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("command timed out after %s and was %s", e.Timeout, e.Outcome)
+}
+
+func (e *TimeoutError) Unwrap() error { return e.Err }
+
+func (e *TimeoutError) Is(target error) bool { return target == ErrTimeout }
+
+// exitError wraps a non-nil error from run in an *ExitError describing the command.
+func (s *Shell) exitError(err error, res *Result, stderrTail []string) error {
+	if err == nil {
+		return nil
+	}
+	tail := make([]string, len(stderrTail))
+	for i, line := range stderrTail {
+		tail[i] = s.mask(line)
+	}
+	return &ExitError{
+		Command:  s.mask(s.commandLine()),
+		Dir:      s.dir,
+		ExitCode: res.ExitCode,
+		Stderr:   tail,
+		LogKVs:   maps.Clone(s.logKVs),
+		Err:      err,
+	}
+}
+
+// mask replaces every secret configured on any stage in str with "***".
+func (s *Shell) mask(str string) string {
+	for _, st := range s.stages() {
+		for _, secret := range st.secrets {
+			if secret != "" {
+				str = strings.ReplaceAll(str, secret, "***")
+			}
+		}
+	}
+	return str
+}
+
+// formatCommand joins argv into a command line, quoting arguments that a
+// POSIX shell would otherwise split or interpret.
+func formatCommand(argv []string) string {
+	quoted := make([]string, len(argv))
+	for i, arg := range argv {
+		if arg == "" || strings.ContainsAny(arg, " \t\n\"'\\$`|&;<>()*?[]{}~#!") {
+			arg = "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+		}
+		quoted[i] = arg
+	}
+	return strings.Join(quoted, " ")
+}
+
+// tailBuffer keeps the last n lines added to it. The stderr of every
+// pipeline stage is added concurrently.
+type tailBuffer struct {
+	n     int
+	mutex sync.Mutex
+	lines []string
+}
+
+func (t *tailBuffer) add(line string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.n <= 0 {
+		return
+	}
+	if len(t.lines) == t.n {
+		t.lines = append(t.lines[:0], t.lines[1:]...)
+	}
+	t.lines = append(t.lines, line)
+}
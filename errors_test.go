@@ -0,0 +1,118 @@
+package gosh
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExitError(t *testing.T) {
+	ConfigureGlobals()
+
+	dir := t.TempDir()
+	var err error
+	captureOutput(func() {
+		_, err = New().
+			Command("sh").
+			Args("-c", "for i in 1 2 3 4; do echo line$i >&2; done; echo token=s3cr3t >&2; exit 2").
+			Arg("--token=s3cr3t").
+			Secret("s3cr3t").
+			SecretArg("hunter2").
+			Dir(dir).
+			LogKV("deploymentId", "d1").
+			StderrTail(3).
+			Exec()
+	})
+
+	var exitErr *ExitError
+	if !errors.As(err, &exitErr) {
+		t.Fatalf("expected *ExitError, got %T: %v", err, err)
+	}
+	if exitErr.ExitCode != 2 {
+		t.Errorf("expected exit code 2, got %d", exitErr.ExitCode)
+	}
+	if exitErr.Dir != dir {
+		t.Errorf("expected dir %q, got %q", dir, exitErr.Dir)
+	}
+	if strings.Contains(exitErr.Command, "s3cr3t") || !strings.Contains(exitErr.Command, "--token=*** ***") {
+		t.Errorf("expected secret to be masked in command, got %q", exitErr.Command)
+	}
+	wantTail := []string{"line3", "line4", "token=***"}
+	if strings.Join(exitErr.Stderr, "|") != strings.Join(wantTail, "|") {
+		t.Errorf("expected stderr tail %q, got %q", wantTail, exitErr.Stderr)
+	}
+	if exitErr.LogKVs["deploymentId"] != "d1" {
+		t.Errorf("expected log KVs to be carried, got %v", exitErr.LogKVs)
+	}
+	if strings.Contains(err.Error(), "s3cr3t") || !strings.Contains(err.Error(), "exit status 2") {
+		t.Errorf("unexpected error message %q", err.Error())
+	}
+
+	var execErr *exec.ExitError
+	if !errors.As(err, &execErr) {
+		t.Error("expected underlying *exec.ExitError to be reachable")
+	}
+}
+
+func TestExitErrorSentinels(t *testing.T) {
+	ConfigureGlobals()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	testCases := []struct {
+		name     string
+		run      func() error
+		sentinel error
+	}{
+		{
+			name: "not found",
+			run: func() error {
+				_, err := New().Arg("gosh-command-that-does-not-exist").Exec()
+				return err
+			},
+			sentinel: ErrNotFound,
+		},
+		{
+			name: "timeout",
+			run: func() error {
+				return New().Args("sleep", "10").Timeout(50 * time.Millisecond).Stream()
+			},
+			sentinel: ErrTimeout,
+		},
+		{
+			name: "cancelled",
+			run: func() error {
+				_, err := New().Args("sleep", "10").ExecContext(ctx)
+				return err
+			},
+			sentinel: ErrCancelled,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var err error
+			captureOutput(func() { err = tc.run() })
+
+			var exitErr *ExitError
+			if !errors.As(err, &exitErr) {
+				t.Fatalf("expected *ExitError, got %T: %v", err, err)
+			}
+			if !errors.Is(err, tc.sentinel) {
+				t.Errorf("expected error to match %v, got %v", tc.sentinel, err)
+			}
+		})
+	}
+}
+
+func TestFormatCommand(t *testing.T) {
+	got := formatCommand([]string{"sh", "-c", "echo 'hi' $HOME", ""})
+	want := `sh -c 'echo '\''hi'\'' $HOME' ''`
+	if got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+}
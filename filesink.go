@@ -0,0 +1,116 @@
+package gosh
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"sync"
+)
+
+// FileSink is a Sink that appends log lines to a local file, rotating it
+// when it would grow past a size limit. Rotated files are renamed to
+// path.1, path.2 and so on, path.1 being the most recent, and the oldest
+// is removed once there are more than the configured number of backups.
+// Writes go straight to the file, which is opened on the first write.
+type FileSink struct {
+	path       string
+	maxBytes   int64
+	maxBackups int
+
+	mutex sync.Mutex
+	file  *os.File
+	size  int64
+}
+
+// NewFileSink returns a FileSink writing to path. The file is rotated
+// before a write that would take it past maxBytes, unless maxBytes is zero,
+// and up to maxBackups rotated files are kept.
+func NewFileSink(path string, maxBytes int64, maxBackups int) *FileSink {
+	return &FileSink{path: path, maxBytes: maxBytes, maxBackups: maxBackups}
+}
+
+// Write implements io.Writer interface
+func (f *FileSink) Write(p []byte) (int, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.file == nil {
+		if err := f.open(); err != nil {
+			return 0, err
+		}
+	}
+	if f.maxBytes > 0 && f.size > 0 && f.size+int64(len(p)) > f.maxBytes {
+		if err := f.rotate(); err != nil {
+			return 0, fmt.Errorf("rotating log file: %w", err)
+		}
+	}
+
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// Flush implements Sink. Writes are not buffered, so there is nothing to do.
+func (f *FileSink) Flush() error {
+	return nil
+}
+
+// Close closes the file. The next write opens it again.
+func (f *FileSink) Close() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
+
+// open opens the file for appending and records its current size.
+func (f *FileSink) open() error {
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return fmt.Errorf("opening log file: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("opening log file: %w", err)
+	}
+	f.file = file
+	f.size = info.Size()
+	return nil
+}
+
+// rotate shifts the backups up by one, moves the current file to path.1
+// and opens a new, empty file.
+func (f *FileSink) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+	f.file = nil
+
+	if f.maxBackups > 0 {
+		os.Remove(f.backup(f.maxBackups))
+		for i := f.maxBackups - 1; i >= 1; i-- {
+			// Missing backups are expected until the file has rotated enough times
+			if err := os.Rename(f.backup(i), f.backup(i+1)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return err
+			}
+		}
+		if err := os.Rename(f.path, f.backup(1)); err != nil {
+			return err
+		}
+	} else if err := os.Remove(f.path); err != nil {
+		return err
+	}
+	return f.open()
+}
+
+// backup returns the name of the i-th rotated file.
+func (f *FileSink) backup(i int) string {
+	return fmt.Sprintf("%s.%d", f.path, i)
+}
@@ -0,0 +1,53 @@
+package gosh
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFileSinkRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "build.log")
+	// Each line is 9 bytes, so every file holds two lines
+	f := NewFileSink(path, 20, 2)
+	for i := 0; i < 7; i++ {
+		fmt.Fprintf(f, "line %03d\n", i)
+	}
+	f.Close()
+
+	want := map[string]string{
+		path:        "line 006\n",
+		path + ".1": "line 004\nline 005\n",
+		path + ".2": "line 002\nline 003\n",
+	}
+	for name, content := range want {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatalf("expected %s to exist: %v", name, err)
+		}
+		if string(data) != content {
+			t.Errorf("expected %s to contain %q, got %q", filepath.Base(name), content, data)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); err == nil {
+		t.Errorf("expected at most 2 backups")
+	}
+}
+
+func TestFileSinkAppendsAfterReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "build.log")
+	os.WriteFile(path, []byte("existing\n"), 0o644)
+
+	f := NewFileSink(path, 0, 0)
+	f.Write([]byte("first run\n"))
+	f.Close()
+	f.Write([]byte("second run\n"))
+	f.Close()
+
+	data, _ := os.ReadFile(path)
+	if got := strings.Split(strings.TrimSpace(string(data)), "\n"); len(got) != 3 {
+		t.Errorf("expected lines to be appended across runs, got %q", data)
+	}
+}
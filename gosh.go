@@ -1,15 +1,12 @@
 package gosh
 
 import (
-	"bufio"
 	"bytes"
-	"fmt"
+	"context"
 	"io"
 	"net/http"
 	"os"
-	"os/exec"
 	"strings"
-	"sync"
 	"time"
 
 	"github.com/rs/zerolog"
@@ -23,121 +20,6 @@ func ConfigureGlobals() {
 	zerolog.TimeFieldFormat = zerolog.TimeFormatUnix
 }
 
-// HTTPStreamWriter implements io.Writer for sending logs to HTTP endpoints
-type HTTPStreamWriter struct {
-	url     string
-	client  *http.Client
-	buffer  bytes.Buffer
-	mutex   sync.Mutex
-	headers http.Header
-	wg      sync.WaitGroup
-}
-
-// NewHTTPStreamWriter creates a new HTTP stream writer
-func NewHTTPStreamWriter(url string, headers http.Header) *HTTPStreamWriter {
-	return &HTTPStreamWriter{
-		url:     url,
-		client:  &http.Client{Timeout: 30 * time.Second}, // Increased timeout
-		headers: headers,
-	}
-}
-
-// Write implements io.Writer interface
-func (w *HTTPStreamWriter) Write(p []byte) (n int, err error) {
-	w.mutex.Lock()
-	defer w.mutex.Unlock()
-
-	// Add incoming data to buffer
-	w.buffer.Write(p)
-
-	// Process complete lines (JSON objects end with newlines)
-	for {
-		line, err := w.buffer.ReadBytes('\n')
-		if err != nil {
-			// No complete line available, put data back and break
-			w.buffer.Write(line)
-			break
-		}
-
-		// Send complete line to HTTP endpoint
-		w.wg.Add(1)
-		go func(data []byte) {
-			defer w.wg.Done()
-
-			// Console log the payload being sent
-			// fmt.Printf("HTTP Stream Payload: %s", string(data))
-
-			req, err := http.NewRequest("POST", w.url, bytes.NewBuffer(data))
-			if err != nil {
-				fmt.Printf("HTTP Stream Error creating request: %v\n", err)
-				return
-			}
-			req.Header.Set("Content-Type", "application/json")
-			for key, values := range w.headers {
-				for _, value := range values {
-					req.Header.Add(key, value)
-				}
-			}
-
-			resp, err := w.client.Do(req)
-			if err != nil {
-				fmt.Printf("HTTP Stream Error sending request: %v\n", err)
-				return
-			}
-			defer resp.Body.Close()
-
-			if resp.StatusCode >= 400 {
-				fmt.Printf("HTTP Stream Error response status: %s\n", resp.Status)
-			}
-		}(line)
-	}
-
-	return len(p), nil
-}
-
-// Close closes the writer and waits for all HTTP requests to complete
-func (w *HTTPStreamWriter) Close() error {
-	// Send any remaining buffered data
-	w.mutex.Lock()
-	if w.buffer.Len() > 0 {
-		remaining := w.buffer.Bytes()
-		w.buffer.Reset()
-		w.mutex.Unlock()
-
-		// Send remaining data if any
-		w.wg.Add(1)
-		go func(data []byte) {
-			defer w.wg.Done()
-			fmt.Printf("HTTP Stream Final Payload: %s", string(data))
-
-			req, err := http.NewRequest("POST", w.url, bytes.NewBuffer(data))
-			if err != nil {
-				fmt.Printf("HTTP Stream Error creating final request: %v\n", err)
-				return
-			}
-			req.Header.Set("Content-Type", "application/json")
-			for key, values := range w.headers {
-				for _, value := range values {
-					req.Header.Add(key, value)
-				}
-			}
-
-			resp, err := w.client.Do(req)
-			if err != nil {
-				fmt.Printf("HTTP Stream Error sending final request: %v\n", err)
-				return
-			}
-			defer resp.Body.Close()
-		}(remaining)
-	} else {
-		w.mutex.Unlock()
-	}
-
-	// Wait for all HTTP requests to complete
-	w.wg.Wait()
-	return nil
-}
-
 // Shell is the builder for executing shell commands.
 type Shell struct {
 	command      string
@@ -145,41 +27,73 @@ type Shell struct {
 	dir          string
 	env          []string
 	log          zerolog.Logger
-	httpWriter   *HTTPStreamWriter
+	sinks        *sinkWriter
+	traces       *HTTPStreamWriter
+	span         *span  // span of the running command, if exported
+	traceParent  string // TRACEPARENT passed to the running command
+	stdin        stdinSource
+	pipe         []*Shell // stages the output is piped into, in order
+	pipefail     bool
+	redirects    []outputTarget
+	mergeStderr  bool
+	onLine       []func(Line)
+	matchers     []matcher
 	streamingURL string
 	httpHeaders  http.Header
 	logKVs       map[string]string
+	timeout      time.Duration
+	gracePeriod  time.Duration
+	waitDelay    time.Duration
+	secrets      []string
+	stderrTail   int
+	lifecycle    bool
+	deliveryErr  error
 }
 
+// DefaultGracePeriod is how long a command is given to exit after SIGTERM
+// before it is killed with SIGKILL, unless changed with GracePeriod().
+const DefaultGracePeriod = 5 * time.Second
+
+// DefaultWaitDelay is how long gosh keeps reading output after a command has
+// exited while its descendants still hold the output pipes open, unless
+// changed with WaitDelay().
+const DefaultWaitDelay = time.Second
+
 // New creates a new Shell builder instance.
 // The first call to Arg() will set the command, subsequent calls add arguments.
 func New() *Shell {
 	return &Shell{
 		log:         zerolog.New(os.Stdout).With().Timestamp().Logger(),
 		httpHeaders: make(http.Header),
+		gracePeriod: DefaultGracePeriod,
+		waitDelay:   DefaultWaitDelay,
+		stderrTail:  DefaultStderrTailLines,
 	}
 }
 
 // WithHTTPStream configures the Shell to stream logs to an HTTP endpoint.
-// This uses io.Pipe for efficient streaming of logs to the HTTP endpoint.
-func (s *Shell) WithHTTPStream(url string) *Shell {
+// Log lines are batched and sent in order; opts tune the HTTPStreamWriter.
+// It is shorthand for WithSink with an HTTPStreamWriter using the headers
+// added with AddHTTPHeader.
+func (s *Shell) WithHTTPStream(url string, opts ...HTTPStreamOption) *Shell {
 	s.streamingURL = url
-	s.httpWriter = NewHTTPStreamWriter(url, s.httpHeaders)
-
-	// Create a multi-writer to send logs both to stdout and HTTP endpoint
-	multiWriter := io.MultiWriter(os.Stdout, s.httpWriter)
-	s.log = zerolog.New(multiWriter).With().Timestamp().Logger()
-
-	return s
+	return s.WithSink(NewHTTPStreamWriter(url, s.httpHeaders, opts...))
 }
 
 // WithHTTPStreamOnly configures the Shell to stream logs only to an HTTP endpoint.
 // This sends logs exclusively to the HTTP endpoint without local stdout output.
-func (s *Shell) WithHTTPStreamOnly(url string) *Shell {
+func (s *Shell) WithHTTPStreamOnly(url string, opts ...HTTPStreamOption) *Shell {
 	s.streamingURL = url
-	s.httpWriter = NewHTTPStreamWriter(url, s.httpHeaders)
-	s.log = zerolog.New(s.httpWriter).With().Timestamp().Logger()
-	return s
+	return s.WithSinkOnly(NewHTTPStreamWriter(url, s.httpHeaders, opts...))
+}
+
+// DeliveryErr returns the error from flushing the sinks at the end of the
+// last Exec or Stream, or nil if every sink accepted every line. Failed
+// sinks are reported as *SinkError values, joined; for an HTTP stream the
+// cause is a *DeliveryError if some log lines were not delivered. A
+// command's own error does not reflect delivery problems.
+func (s *Shell) DeliveryErr() error {
+	return s.deliveryErr
 }
 
 // AddHTTPHeader adds a header to be sent with HTTP stream requests.
@@ -217,6 +131,20 @@ func (s *Shell) Args(args ...string) *Shell {
 	return s
 }
 
+// SecretArg adds an argument like Arg, and masks it wherever the command
+// line appears in errors.
+func (s *Shell) SecretArg(arg string) *Shell {
+	s.secrets = append(s.secrets, arg)
+	return s.Arg(arg)
+}
+
+// Secret registers values, such as tokens embedded in arguments, that are
+// masked wherever the command line or its stderr appears in errors.
+func (s *Shell) Secret(values ...string) *Shell {
+	s.secrets = append(s.secrets, values...)
+	return s
+}
+
 // Command explicitly sets the command, allowing you to separate command setting
 // from argument adding. This is useful if you want to be explicit about the command.
 func (s *Shell) Command(cmd string) *Shell {
@@ -238,6 +166,38 @@ func (s *Shell) Env(key, value string) *Shell {
 	return s
 }
 
+// Timeout sets the maximum time the command may run. When it elapses the
+// command is sent SIGTERM, and SIGKILL if it is still running after the
+// grace period. A zero duration (the default) means no timeout.
+func (s *Shell) Timeout(d time.Duration) *Shell {
+	s.timeout = d
+	return s
+}
+
+// GracePeriod sets how long a command is given to exit after SIGTERM, on
+// timeout or context cancellation, before it is killed with SIGKILL.
+// A zero duration kills the command immediately.
+func (s *Shell) GracePeriod(d time.Duration) *Shell {
+	s.gracePeriod = d
+	return s
+}
+
+// WaitDelay sets how long to keep reading output after the command exits if
+// background descendants still hold its stdout or stderr open. When it
+// elapses the pipes are closed and the remaining process group is killed,
+// so Exec and Stream return instead of blocking until the descendants exit.
+func (s *Shell) WaitDelay(d time.Duration) *Shell {
+	s.waitDelay = d
+	return s
+}
+
+// StderrTail sets how many trailing stderr lines are kept on the ExitError
+// returned when the command fails. Zero disables the tail.
+func (s *Shell) StderrTail(n int) *Shell {
+	s.stderrTail = n
+	return s
+}
+
 func (s *Shell) LogKV(key, value string) *Shell {
 	if s.logKVs == nil {
 		s.logKVs = make(map[string]string)
@@ -262,54 +222,103 @@ func (s *Shell) Logger(logger zerolog.Logger) *Shell {
 // trimmed string and an error if the command fails. On success, it logs stdout
 // as an info message. On failure, it logs stderr as an error message.
 func (s *Shell) Exec() (string, error) {
-	if s.command == "" {
-		return "", fmt.Errorf("no command specified - use Arg() or Command() to set the command")
+	return s.ExecContext(context.Background())
+}
+
+// ExecContext is like Exec but kills the command if ctx is done before the
+// command completes. In that case a "cancelled" event is logged and the
+// returned error wraps ctx.Err().
+func (s *Shell) ExecContext(ctx context.Context) (string, error) {
+	res, err := s.ExecResultContext(ctx)
+	if res == nil {
+		return "", err
 	}
+	return strings.TrimSpace(string(res.Stdout)), err
+}
 
-	// Clean up HTTP writer when done
-	defer func() {
-		if s.httpWriter != nil {
-			s.httpWriter.Close()
-		}
-	}()
+// ExecResult is like Exec but returns a Result describing the finished
+// command, including its raw stdout and stderr, exit code and timing.
+// A failed command returns an *ExitError along with the Result.
+func (s *Shell) ExecResult() (*Result, error) {
+	return s.ExecResultContext(context.Background())
+}
 
-	cmd := exec.Command(s.command, s.args...)
+// ExecResultContext is like ExecResult but kills the command if ctx is done
+// before the command completes. The Result is non-nil whenever a command
+// was configured, even if it failed to start.
+func (s *Shell) ExecResultContext(ctx context.Context) (*Result, error) {
+	if !s.hasCommand() {
+		return nil, errNoCommand
+	}
 
-	if s.dir != "" {
-		cmd.Dir = s.dir
+	s.startSpan(ctx)
+	s.sinks.setLogKVs(s.logKVs)
+	// Flush and close the sinks when done
+	defer s.closeSinks()
+
+	ctx, abort := context.WithCancelCause(ctx)
+	defer abort(nil)
+	watch := s.newOutputWatcher(nil, abort)
+
+	cmds := s.buildCmds()
+
+	// Each stage of a pipeline writes to its own stderr buffer. Output is
+	// also split into lines as it arrives if hooks or matchers watch it.
+	var stdoutBuf bytes.Buffer
+	stderrBufs := make([]bytes.Buffer, len(cmds))
+	var watched []*lineWriter
+	cmds[len(cmds)-1].Stdout = &stdoutBuf
+	for i, cmd := range cmds {
+		cmd.Stderr = &stderrBufs[i]
 	}
-	if len(s.env) > 0 {
-		cmd.Env = append(os.Environ(), s.env...)
+	if lines := watch.emitter(); lines != nil {
+		last := cmds[len(cmds)-1]
+		stdout := &lineWriter{fn: func(line string) { lines.emit(Stdout, 0, line) }}
+		last.Stdout = io.MultiWriter(last.Stdout, stdout)
+		watched = append(watched, stdout)
+		for i, cmd := range cmds {
+			stderr := &lineWriter{fn: func(line string) { lines.emit(Stderr, i, line) }}
+			cmd.Stderr = io.MultiWriter(cmd.Stderr, stderr)
+			watched = append(watched, stderr)
+		}
 	}
 
-	var stdoutBuf, stderrBuf bytes.Buffer
-	cmd.Stdout = &stdoutBuf
-	cmd.Stderr = &stderrBuf
-
-	err := cmd.Run()
+	res, err := s.run(ctx, cmds)
+	for _, w := range watched {
+		w.Flush()
+	}
+	err = watch.finish(res, err)
+	res.Stdout = stdoutBuf.Bytes()
+	for i := range stderrBufs {
+		res.Stderr = append(res.Stderr, stderrBufs[i].Bytes()...)
+	}
 
 	stdout := strings.TrimSpace(stdoutBuf.String())
-	stderr := strings.TrimSpace(stderrBuf.String())
 
 	// Always log stderr if present (even on success, some commands write to stderr)
-	if stderr != "" {
-		logEvent := s.log.Error()
-		for k, v := range s.logKVs {
-			logEvent = logEvent.Str(k, v)
+	for i := range stderrBufs {
+		if stderr := strings.TrimSpace(stderrBufs[i].String()); stderr != "" {
+			s.stderrEvent(i).Msg(stderr)
 		}
-		logEvent.Msg(stderr)
 	}
 
 	// Always log stdout if present
 	if stdout != "" {
-		logEvent := s.log.Info()
-		for k, v := range s.logKVs {
-			logEvent = logEvent.Str(k, v)
-		}
-		logEvent.Msg(stdout)
+		s.withLogKVs(s.log.Info()).Msg(stdout)
 	}
 
-	return stdout, err
+	if res.PID != 0 {
+		s.logFinished(res, cmds)
+	}
+
+	tail := &tailBuffer{n: s.stderrTail}
+	tailLines := &lineWriter{fn: tail.add}
+	tailLines.Write(res.Stderr)
+	tailLines.Flush()
+
+	err = s.exitError(err, res, tail.lines)
+	s.endSpan(res, err)
+	return res, err
 }
 
 // Stream executes the configured command with real-time output streaming.
@@ -317,82 +326,82 @@ func (s *Shell) Exec() (string, error) {
 // zerolog, preserving the configured formatting and HTTP streaming settings.
 // Returns an error if the command fails.
 func (s *Shell) Stream() error {
-	if s.command == "" {
-		return fmt.Errorf("no command specified - use Arg() or Command() to set the command")
-	}
+	return s.StreamContext(context.Background())
+}
 
-	// Clean up HTTP writer when done
-	defer func() {
-		if s.httpWriter != nil {
-			s.httpWriter.Close()
-		}
-	}()
+// StreamContext is like Stream but kills the command if ctx is done before
+// the command completes. Lines already produced are still logged and flushed
+// to the HTTP stream, and the returned error wraps ctx.Err().
+func (s *Shell) StreamContext(ctx context.Context) error {
+	_, err := s.StreamResultContext(ctx)
+	return err
+}
 
-	cmd := exec.Command(s.command, s.args...)
+// StreamResult is like Stream but returns a Result describing the finished
+// command. Output is streamed rather than captured, so Result.Stdout and
+// Result.Stderr are nil.
+func (s *Shell) StreamResult() (*Result, error) {
+	return s.StreamResultContext(context.Background())
+}
 
-	if s.dir != "" {
-		cmd.Dir = s.dir
-	}
-	if len(s.env) > 0 {
-		cmd.Env = append(os.Environ(), s.env...)
-	}
+// StreamResultContext is like StreamResult but kills the command if ctx is
+// done before the command completes.
+func (s *Shell) StreamResultContext(ctx context.Context) (*Result, error) {
+	return s.stream(ctx, nil)
+}
 
-	// Create pipes for real-time streaming
-	stdoutPipe, err := cmd.StdoutPipe()
-	if err != nil {
-		return fmt.Errorf("failed to create stdout pipe: %w", err)
+// stream runs the command for the Stream variants and Lines, passing every
+// output line to onLine, if not nil, after it has been logged.
+func (s *Shell) stream(ctx context.Context, onLine func(Line)) (*Result, error) {
+	if !s.hasCommand() {
+		return nil, errNoCommand
 	}
 
-	stderrPipe, err := cmd.StderrPipe()
-	if err != nil {
-		return fmt.Errorf("failed to create stderr pipe: %w", err)
+	s.startSpan(ctx)
+	s.sinks.setLogKVs(s.logKVs)
+	// Flush and close the sinks when done
+	defer s.closeSinks()
+
+	ctx, abort := context.WithCancelCause(ctx)
+	defer abort(nil)
+	watch := s.newOutputWatcher(onLine, abort)
+	lines := watch.emitter()
+
+	cmds := s.buildCmds()
+
+	// Stream stdout through zerolog as info messages and stderr as error
+	// messages, one log entry per line. Only the last stage of a pipeline
+	// writes to stdout, and every stage to stderr.
+	tail := &tailBuffer{n: s.stderrTail}
+	stdout := &lineWriter{fn: func(line string) {
+		s.withLogKVs(s.log.Info()).Msg(line)
+		lines.emit(Stdout, 0, line)
+	}}
+	stderrs := make([]*lineWriter, len(cmds))
+	for i, cmd := range cmds {
+		stderrs[i] = &lineWriter{fn: func(line string) {
+			s.stderrEvent(i).Msg(line)
+			tail.add(line)
+			lines.emit(Stderr, i, line)
+		}}
+		cmd.Stderr = stderrs[i]
 	}
+	cmds[len(cmds)-1].Stdout = stdout
 
-	// Start the command
-	if err := cmd.Start(); err != nil {
-		return fmt.Errorf("failed to start command: %w", err)
-	}
+	res, err := s.run(ctx, cmds)
 
-	// Use WaitGroup to handle concurrent streaming
-	var wg sync.WaitGroup
-
-	// Stream stdout through zerolog as info messages
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		scanner := bufio.NewScanner(stdoutPipe)
-		for scanner.Scan() {
-			line := scanner.Text()
-			if line != "" {
-				logEvent := s.log.Info()
-				for k, v := range s.logKVs {
-					logEvent = logEvent.Str(k, v)
-				}
-				logEvent.Msg(line)
-			}
-		}
-	}()
-
-	// Stream stderr through zerolog as error messages
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		scanner := bufio.NewScanner(stderrPipe)
-		for scanner.Scan() {
-			line := scanner.Text()
-			if line != "" {
-				logEvent := s.log.Error()
-				for k, v := range s.logKVs {
-					logEvent = logEvent.Str(k, v)
-				}
-				logEvent.Msg(line)
-			}
-		}
-	}()
+	// Log any trailing output that was not terminated by a newline
+	stdout.Flush()
+	for _, stderr := range stderrs {
+		stderr.Flush()
+	}
+	err = watch.finish(res, err)
 
-	// Wait for all streaming to complete
-	wg.Wait()
+	if res.PID != 0 {
+		s.logFinished(res, cmds)
+	}
 
-	// Wait for the command to complete and return its error status
-	return cmd.Wait()
+	err = s.exitError(err, res, tail.lines)
+	s.endSpan(res, err)
+	return res, err
 }
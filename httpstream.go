@@ -0,0 +1,497 @@
+package gosh
+
+import (
+	"bytes"
+	"cmp"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// DefaultMaxBatchBytes is the body size at which a batch is sent without
+	// waiting for the flush interval.
+	DefaultMaxBatchBytes = 512 << 10
+	// DefaultFlushInterval is how long lines may wait before a partial batch is sent.
+	DefaultFlushInterval = 500 * time.Millisecond
+)
+
+// HTTPStreamOption configures an HTTPStreamWriter.
+type HTTPStreamOption func(*HTTPStreamWriter)
+
+// WithMaxBatchBytes sets the body size at which a batch is sent immediately.
+// A single line larger than n is sent as a batch of its own.
+func WithMaxBatchBytes(n int) HTTPStreamOption {
+	return func(w *HTTPStreamWriter) {
+		w.maxBatchBytes = n
+	}
+}
+
+// WithFlushInterval sets how long lines may wait before a partial batch is sent.
+func WithFlushInterval(d time.Duration) HTTPStreamOption {
+	return func(w *HTTPStreamWriter) {
+		w.flushInterval = d
+	}
+}
+
+// HTTPStreamWriter implements io.Writer for sending logs to HTTP endpoints.
+//
+// Lines written to it are numbered with a monotonic "seq" field and sent in
+// order by a single background sender, which batches them into NDJSON
+// request bodies of up to the maximum batch size or whatever has
+// accumulated when the flush interval elapses.
+type HTTPStreamWriter struct {
+	url     string
+	client  *http.Client
+	headers http.Header
+
+	onError  func(error)
+	sent     atomic.Uint64
+	failed   atomic.Uint64
+	retried  atomic.Uint64
+	dropped  atomic.Uint64
+	inFlight atomic.Int64
+
+	errMutex   sync.Mutex
+	lastErr    error
+	closeErrs  []error // errors since the last Close
+	closedFail uint64  // failed count at the last Close
+	closedDrop uint64  // dropped count at the last Close
+
+	maxBatchBytes int
+	flushInterval time.Duration
+	retry         RetryPolicy
+	spool         *spool
+	spoolErr      error
+	compression   Compression
+	compressMin   int
+	hmacSecret    []byte
+	tokens        *tokenCache
+	encoder       BatchEncoder
+	maxQueueBytes int
+	overflow      OverflowPolicy
+	sampleEvery   int
+
+	mutex      sync.Mutex
+	spaceFreed *sync.Cond // broadcast when the sender takes records off the queue
+	partial    []byte     // incomplete trailing line
+	queue      [][]byte   // complete records waiting to be sent
+	queued     int        // bytes in queue
+	blocked    int        // writers waiting for space under OverflowBlock
+	overflows  int        // lines that arrived while the queue was full, for sampling
+	seq        uint64
+	running    bool
+	kick       chan struct{}      // wakes the sender when a full batch is queued
+	flushes    chan chan struct{} // asks the sender to send everything queued
+	stop       chan struct{}      // asks the sender to drain the queue and exit
+	done       chan struct{}      // closed when the sender has exited
+	replayStop chan struct{}      // closed by Close to stop the spool replayer
+	replayers  sync.WaitGroup
+}
+
+// NewHTTPStreamWriter creates a new HTTP stream writer
+func NewHTTPStreamWriter(url string, headers http.Header, opts ...HTTPStreamOption) *HTTPStreamWriter {
+	w := &HTTPStreamWriter{
+		url:           url,
+		client:        &http.Client{Timeout: 30 * time.Second}, // Increased timeout
+		headers:       headers,
+		maxBatchBytes: DefaultMaxBatchBytes,
+		flushInterval: DefaultFlushInterval,
+		retry:         DefaultRetryPolicy,
+		compressMin:   DefaultCompressionMinBytes,
+		maxQueueBytes: DefaultMaxQueueBytes,
+		overflow:      OverflowBlock,
+		sampleEvery:   DefaultSampleEvery,
+	}
+	w.spaceFreed = sync.NewCond(&w.mutex)
+	for _, opt := range opts {
+		opt(w)
+	}
+	if w.spoolErr != nil {
+		w.reportError(fmt.Errorf("opening spool: %w", w.spoolErr))
+	}
+	// Replay segments left over from a previous process
+	if w.spool != nil && w.spool.claimReplay() {
+		w.startReplayer()
+	}
+	return w
+}
+
+// Write implements io.Writer interface
+func (w *HTTPStreamWriter) Write(p []byte) (n int, err error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	w.partial = append(w.partial, p...)
+
+	// Split off complete lines (JSON objects end with newlines) before
+	// queueing them, since enqueue may wait and let other writers in.
+	var lines [][]byte
+	for {
+		i := bytes.IndexByte(w.partial, '\n')
+		if i < 0 {
+			break
+		}
+		lines = append(lines, w.partial[:i+1])
+		w.partial = w.partial[i+1:]
+	}
+	w.partial = bytes.Clone(w.partial)
+
+	// A line that would not fit in the queue anyway is cut rather than
+	// buffered without bound.
+	if len(w.partial) >= w.maxQueueBytes {
+		lines = append(lines, append(w.partial, '\n'))
+		w.partial = nil
+	}
+
+	for _, line := range lines {
+		w.enqueue(line)
+	}
+	return len(p), nil
+}
+
+// Flush sends everything queued so far, including an incomplete trailing
+// line, and waits until it has been handed to the endpoint.
+func (w *HTTPStreamWriter) Flush() error {
+	w.mutex.Lock()
+	w.enqueuePartial()
+	if !w.running {
+		w.mutex.Unlock()
+		return nil
+	}
+	flushes, done := w.flushes, w.done
+	w.mutex.Unlock()
+
+	ack := make(chan struct{})
+	select {
+	case flushes <- ack:
+		<-ack
+	case <-done:
+		// Closed concurrently; the sender drained the queue before exiting
+	}
+	return nil
+}
+
+// Close sends any remaining buffered data, stops the background sender and
+// waits for all HTTP requests to complete. It returns a *DeliveryError if
+// any lines written since the previous Close failed or were dropped, so
+// callers know whether the stream is complete. Batches saved to a spool
+// are not counted as failed. The writer can be written to again after
+// Close; a new sender is started on demand.
+func (w *HTTPStreamWriter) Close() error {
+	w.mutex.Lock()
+	w.enqueuePartial()
+	if !w.running {
+		w.mutex.Unlock()
+		w.stopReplayer()
+		return w.deliveryError()
+	}
+	w.running = false
+	stop, done := w.stop, w.done
+	// Writers blocked on a full queue queue their line and return
+	w.spaceFreed.Broadcast()
+	w.mutex.Unlock()
+
+	close(stop)
+	<-done
+	w.stopReplayer()
+	return w.deliveryError()
+}
+
+// enqueuePartial queues an incomplete trailing line, terminating it with a
+// newline. The caller must hold the mutex.
+func (w *HTTPStreamWriter) enqueuePartial() {
+	if len(w.partial) > 0 {
+		w.enqueue(append(w.partial, '\n'))
+		w.partial = nil
+	}
+}
+
+// enqueue numbers a complete line and queues it for the sender, starting the
+// sender if needed and applying the overflow policy if the queue is full.
+// The caller must hold the mutex.
+func (w *HTTPStreamWriter) enqueue(line []byte) {
+	w.startSender()
+
+	// Dropped lines still consume a sequence number, so ingestors can see the gap
+	w.seq++
+	record := withSeq(line, w.seq)
+	if !w.admit(len(record)) {
+		w.dropped.Add(1)
+		return
+	}
+	w.queue = append(w.queue, record)
+	w.queued += len(record)
+
+	if w.queued >= w.maxBatchBytes {
+		w.kickSender()
+	}
+}
+
+// kickSender wakes the sender without waiting for the flush interval.
+// The caller must hold the mutex.
+func (w *HTTPStreamWriter) kickSender() {
+	select {
+	case w.kick <- struct{}{}:
+	default:
+	}
+}
+
+// startSender starts the background sender if it is not running.
+// The caller must hold the mutex.
+func (w *HTTPStreamWriter) startSender() {
+	if !w.running {
+		w.running = true
+		prev := w.done
+		w.kick = make(chan struct{}, 1)
+		w.flushes = make(chan chan struct{})
+		w.stop = make(chan struct{})
+		w.done = make(chan struct{})
+		go func(kick chan struct{}, flushes chan chan struct{}, stop, done chan struct{}) {
+			// A sender stopped by a concurrent Close may still be draining;
+			// wait for it so batches stay in order.
+			if prev != nil {
+				<-prev
+			}
+			w.sender(kick, flushes, stop, done)
+		}(w.kick, w.flushes, w.stop, w.done)
+	}
+}
+
+// withSeq returns a copy of a JSON object line with a "seq" field added as
+// its first member. Lines that are not JSON objects are copied unchanged.
+func withSeq(line []byte, seq uint64) []byte {
+	trimmed := bytes.TrimLeft(line, " \t")
+	if len(trimmed) == 0 || trimmed[0] != '{' {
+		return bytes.Clone(line)
+	}
+	rest := bytes.TrimLeft(trimmed[1:], " \t")
+
+	record := make([]byte, 0, len(line)+24)
+	record = append(record, `{"seq":`...)
+	record = strconv.AppendUint(record, seq, 10)
+	if len(rest) > 0 && rest[0] != '}' {
+		record = append(record, ',')
+	}
+	return append(record, rest...)
+}
+
+// sender sends queued records in order until stop is closed, then drains
+// the queue and closes done.
+func (w *HTTPStreamWriter) sender(kick <-chan struct{}, flushes <-chan chan struct{}, stop, done chan struct{}) {
+	defer close(done)
+
+	ticker := time.NewTicker(w.flushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-kick:
+			w.sendQueued(false)
+		case <-ticker.C:
+			w.sendQueued(true)
+		case ack := <-flushes:
+			w.sendQueued(true)
+			close(ack)
+		case <-stop:
+			w.sendQueued(true)
+			return
+		}
+	}
+}
+
+// sendQueued sends full batches from the queue, and the final partial batch
+// too if all is set.
+func (w *HTTPStreamWriter) sendQueued(all bool) {
+	for {
+		batch := w.nextBatch(all)
+		if batch == nil {
+			return
+		}
+		w.send(batch)
+	}
+}
+
+// nextBatch removes and returns the next batch of records from the queue,
+// or nil if there is nothing (or, unless all is set, not a full batch) to send.
+func (w *HTTPStreamWriter) nextBatch(all bool) [][]byte {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	// Blocked writers are waiting for whatever is queued to be sent
+	if len(w.queue) == 0 || (!all && w.blocked == 0 && w.queued < w.maxBatchBytes) {
+		return nil
+	}
+
+	n, size := 0, 0
+	for n < len(w.queue) && (n == 0 || size+len(w.queue[n]) <= w.maxBatchBytes) {
+		size += len(w.queue[n])
+		n++
+	}
+	batch := w.queue[:n:n]
+	w.queue = w.queue[n:]
+	w.queued -= size
+	w.spaceFreed.Broadcast()
+	return batch
+}
+
+// send posts a batch of records as a single NDJSON body. With a spool,
+// batches go to the spool instead while older batches are waiting there,
+// and lines that still fail after retries are spooled.
+func (w *HTTPStreamWriter) send(batch [][]byte) {
+	body := bytes.Join(batch, nil)
+	if w.spool != nil && w.spool.pending() {
+		w.spoolBatch(body)
+		return
+	}
+
+	rest, err := w.deliver(body)
+	if err != nil && w.spool != nil && retryable(err) {
+		w.spoolBatch(rest)
+		return
+	}
+	if err != nil {
+		lines := bytes.Count(rest, []byte("\n"))
+		w.failed.Add(uint64(lines))
+		w.reportError(fmt.Errorf("delivering batch of %d lines: %w", lines, err))
+	}
+}
+
+// deliver sends body, retrying according to the retry policy. Lines the
+// endpoint rejects individually are retried on their own. It returns the
+// lines that were not delivered along with the error.
+func (w *HTTPStreamWriter) deliver(body []byte) ([]byte, error) {
+	for attempt := 1; ; attempt++ {
+		rest, err := w.attempt(body)
+		if err == nil || !retryable(err) || attempt >= w.retry.MaxAttempts {
+			return rest, err
+		}
+		body = rest
+
+		var retryAfter time.Duration
+		var statusErr *HTTPStatusError
+		if errors.As(err, &statusErr) {
+			retryAfter = statusErr.RetryAfter
+		}
+		w.retried.Add(1)
+		time.Sleep(w.retry.delay(attempt, retryAfter))
+	}
+}
+
+// attempt makes a single delivery attempt of body and counts the lines that
+// were delivered, or rejected for good by a BatchResponseChecker. It returns
+// the lines to send again, with the error, if the attempt failed or some
+// lines were rejected with a retryable error.
+func (w *HTTPStreamWriter) attempt(body []byte) ([]byte, error) {
+	p, err := w.prepare(body)
+	if err != nil {
+		return body, err
+	}
+	rejected, err := w.post(p)
+	if err != nil {
+		return body, err
+	}
+
+	var retry []byte
+	var retryErr, failErr error
+	failed := 0
+	for _, r := range rejected {
+		if retryable(r.Err) {
+			retry = append(append(retry, r.Line...), '\n')
+			retryErr = r.Err
+		} else {
+			failed++
+			failErr = cmp.Or(failErr, r.Err)
+		}
+	}
+	w.sent.Add(uint64(bytes.Count(body, []byte("\n")) - len(rejected)))
+	if failed > 0 {
+		w.failed.Add(uint64(failed))
+		w.reportError(fmt.Errorf("endpoint rejected %d lines: %w", failed, failErr))
+	}
+	if retry != nil {
+		return retry, fmt.Errorf("endpoint rejected %d lines: %w", len(rejected)-failed, retryErr)
+	}
+	return nil, nil
+}
+
+// post makes a single delivery attempt. When the endpoint rejects the token
+// from a token provider, the request is repeated once with a fresh token, as
+// tokens can be revoked or rotated before they expire. Lines of an accepted
+// batch that the endpoint reports as failed are returned.
+func (w *HTTPStreamWriter) post(p payload) ([]RejectedLine, error) {
+	resp, err := w.postOnce(p, false)
+	// A plain type assertion: a token endpoint answering 401 must not
+	// trigger a refresh
+	if statusErr, ok := err.(*HTTPStatusError); ok && w.tokens != nil && statusErr.StatusCode == http.StatusUnauthorized {
+		resp, err = w.postOnce(p, true)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if checker, ok := w.encoder.(BatchResponseChecker); ok {
+		return checker.CheckResponse(p.batch, resp), nil
+	}
+	return nil, nil
+}
+
+// postOnce sends a single request, fetching a new token first if
+// refreshToken is set. It returns the response body if the encoder checks
+// responses.
+func (w *HTTPStreamWriter) postOnce(p payload, refreshToken bool) ([]byte, error) {
+	w.inFlight.Add(1)
+	defer w.inFlight.Add(-1)
+
+	req, err := http.NewRequest("POST", w.url, bytes.NewReader(p.body))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errCreateRequest, err)
+	}
+	req.Header.Set("Content-Type", p.contentType)
+	if p.contentEncoding != "" {
+		req.Header.Set("Content-Encoding", p.contentEncoding)
+	}
+	for key, values := range w.headers {
+		req.Header[http.CanonicalHeaderKey(key)] = values
+	}
+	if w.tokens != nil {
+		token, err := w.tokens.get(req.Context(), refreshToken)
+		if err != nil {
+			return nil, fmt.Errorf("fetching token: %w", err)
+		}
+		req.Header.Set("Authorization", token.header())
+	}
+	if err := w.sign(req, p.body); err != nil {
+		return nil, fmt.Errorf("signing request: %w", err)
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		// Drain the body so the connection can be reused
+		io.Copy(io.Discard, resp.Body)
+		statusErr := &HTTPStatusError{StatusCode: resp.StatusCode, Status: resp.Status}
+		if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable {
+			statusErr.RetryAfter = parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+		}
+		return nil, statusErr
+	}
+
+	if _, ok := w.encoder.(BatchResponseChecker); !ok {
+		io.Copy(io.Discard, resp.Body)
+		return nil, nil
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("reading response: %w", err)
+	}
+	return body, nil
+}
@@ -0,0 +1,159 @@
+package gosh
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingServer is an httptest server that records every request it receives.
+type recordingServer struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	requests []*http.Request
+	bodies   []string
+}
+
+func newRecordingServer(t *testing.T) *recordingServer {
+	t.Helper()
+	rs := &recordingServer{}
+	rs.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		rs.mu.Lock()
+		rs.requests = append(rs.requests, r)
+		rs.bodies = append(rs.bodies, string(body))
+		rs.mu.Unlock()
+	}))
+	t.Cleanup(rs.Close)
+	return rs
+}
+
+// records returns every NDJSON line received so far, in order.
+func (rs *recordingServer) records(t *testing.T) []map[string]any {
+	t.Helper()
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	var records []map[string]any
+	for _, body := range rs.bodies {
+		records = append(records, parseLogLines(t, body)...)
+	}
+	return records
+}
+
+func (rs *recordingServer) requestCount() int {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	return len(rs.requests)
+}
+
+func TestHTTPStreamWriterOrderedBatches(t *testing.T) {
+	srv := newRecordingServer(t)
+
+	w := NewHTTPStreamWriter(srv.URL, http.Header{"Token": {"abc"}}, WithMaxBatchBytes(4096))
+	const lines = 1000
+	for i := 0; i < lines; i++ {
+		fmt.Fprintf(w, "{\"msg\":\"line %d\"}\n", i)
+	}
+	w.Close()
+
+	records := srv.records(t)
+	if len(records) != lines {
+		t.Fatalf("expected %d records, got %d", lines, len(records))
+	}
+	for i, record := range records {
+		if record["msg"] != fmt.Sprintf("line %d", i) || record["seq"] != float64(i+1) {
+			t.Fatalf("record %d out of order: %v", i, record)
+		}
+	}
+	if n := srv.requestCount(); n >= lines/10 {
+		t.Errorf("expected lines to be batched, got %d requests", n)
+	}
+
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	for i, body := range srv.bodies {
+		if len(body) > 4096 {
+			t.Errorf("batch %d exceeds the maximum batch size: %d bytes", i, len(body))
+		}
+	}
+	if ct := srv.requests[0].Header.Get("Content-Type"); ct != "application/x-ndjson" {
+		t.Errorf("expected NDJSON content type, got %q", ct)
+	}
+	if token := srv.requests[0].Header.Get("Token"); token != "abc" {
+		t.Errorf("expected configured header, got %q", token)
+	}
+}
+
+func TestHTTPStreamWriterFlushInterval(t *testing.T) {
+	srv := newRecordingServer(t)
+
+	w := NewHTTPStreamWriter(srv.URL, nil, WithFlushInterval(20*time.Millisecond))
+	defer w.Close()
+	io.WriteString(w, "{\"msg\":\"one\"}\n{\"msg\":\"partial")
+
+	deadline := time.Now().Add(2 * time.Second)
+	for srv.requestCount() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("expected a partial batch to be sent after the flush interval")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	io.WriteString(w, "\"}")
+	w.Flush()
+	records := srv.records(t)
+	if len(records) != 2 || records[1]["msg"] != "partial" || records[1]["seq"] != float64(2) {
+		t.Errorf("expected Flush to send the trailing line, got %v", records)
+	}
+}
+
+func TestShellHTTPStreamKeepsOrder(t *testing.T) {
+	ConfigureGlobals()
+	srv := newRecordingServer(t)
+
+	err := New().
+		WithHTTPStreamOnly(srv.URL).
+		Command("sh").
+		Args("-c", "i=0; while [ $i -lt 200 ]; do echo line$i; i=$((i+1)); done").
+		Stream()
+	if err != nil {
+		t.Fatalf("expected command to succeed, but it failed: %v", err)
+	}
+
+	records := srv.records(t)
+	if len(records) != 200 {
+		t.Fatalf("expected 200 records, got %d", len(records))
+	}
+	for i, record := range records {
+		if record["msg"] != fmt.Sprintf("line%d", i) {
+			t.Fatalf("record %d out of order: %v", i, record)
+		}
+	}
+}
+
+func TestWithSeq(t *testing.T) {
+	testCases := map[string]string{
+		"{\"msg\":\"a\"}\n": "{\"seq\":7,\"msg\":\"a\"}\n",
+		"{}\n":              "{\"seq\":7}\n",
+		"plain text\n":      "plain text\n",
+	}
+	for in, want := range testCases {
+		got := string(withSeq([]byte(in), 7))
+		if got != want {
+			t.Errorf("withSeq(%q) = %q, want %q", in, got, want)
+		}
+		if strings.HasPrefix(got, "{") {
+			var v map[string]any
+			if err := json.Unmarshal([]byte(got), &v); err != nil {
+				t.Errorf("withSeq(%q) produced invalid JSON: %v", in, err)
+			}
+		}
+	}
+}
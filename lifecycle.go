@@ -0,0 +1,117 @@
+package gosh
+
+import (
+	"os/exec"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// Lifecycle events, logged in the "event" field when WithLifecycleEvents is enabled.
+const (
+	EventCommandStarted       = "command_started"
+	EventCommandFinished      = "command_finished"
+	EventCommandFailedToStart = "command_failed_to_start"
+)
+
+// WithLifecycleEvents enables structured command_started, command_finished
+// and command_failed_to_start events in the log stream, alongside the
+// command's output. Each carries an "event" field so ingestors can build a
+// status timeline, for example per deploymentId set with LogKV.
+func (s *Shell) WithLifecycleEvents() *Shell {
+	s.lifecycle = true
+	return s
+}
+
+// lifecycleEvent starts a lifecycle log event with the configured log KVs,
+// or returns nil, which zerolog treats as a no-op, if lifecycle events are disabled.
+func (s *Shell) lifecycleEvent(e *zerolog.Event, event string) *zerolog.Event {
+	if !s.lifecycle {
+		return nil
+	}
+	return s.withLogKVs(e).Str("event", event)
+}
+
+// logStarted logs the command_started event for a started command. For a
+// pipeline, argv, dir and pid are those of the first stage, and the whole
+// command line is logged as "pipeline".
+func (s *Shell) logStarted(cmds []*exec.Cmd) {
+	e := s.lifecycleEvent(s.log.Info(), EventCommandStarted).
+		Strs("argv", s.maskedArgv()).
+		Str("dir", cmds[0].Dir).
+		Int("pid", cmds[0].Process.Pid)
+	if len(cmds) > 1 {
+		e = e.Str("pipeline", s.mask(s.commandLine()))
+	}
+	e.Msg("command started")
+}
+
+// logFailedToStart logs the command_failed_to_start event.
+func (s *Shell) logFailedToStart(err error) {
+	s.lifecycleEvent(s.log.Error(), EventCommandFailedToStart).
+		Strs("argv", s.maskedArgv()).
+		Str("error", err.Error()).
+		Msg("command failed to start")
+}
+
+// logFinished logs the command_finished event with the exit status,
+// duration and resource usage of a command that ran. The resource usage of
+// a pipeline is that of all its stages, with the largest peak RSS.
+func (s *Shell) logFinished(res *Result, cmds []*exec.Cmd) {
+	level := s.log.Info()
+	if !res.Success() {
+		level = s.log.Error()
+	}
+	e := s.lifecycleEvent(level, EventCommandFinished).
+		Int("pid", res.PID).
+		Int("exit_code", res.ExitCode).
+		Int64("duration_ms", res.Duration.Milliseconds()).
+		Bool("timed_out", res.TimedOut)
+	if res.Signal != nil {
+		e = e.Str("signal", res.Signal.String())
+	}
+	if s.stdin != nil {
+		e = e.Int64("stdin_bytes", res.StdinBytes)
+	}
+	if len(res.Stages) > 0 {
+		codes := make([]int, len(res.Stages))
+		for i, stage := range res.Stages {
+			codes[i] = stage.ExitCode
+		}
+		e = e.Ints("stage_exit_codes", codes)
+	}
+
+	var userCPU, systemCPU time.Duration
+	var maxRSS int64
+	waited, hasRSS := false, false
+	for _, cmd := range cmds {
+		ps := cmd.ProcessState
+		if ps == nil {
+			continue
+		}
+		waited = true
+		userCPU += ps.UserTime()
+		systemCPU += ps.SystemTime()
+		if rss, ok := maxRSSKB(ps); ok {
+			hasRSS = true
+			maxRSS = max(maxRSS, rss)
+		}
+	}
+	if waited {
+		e = e.Int64("user_cpu_ms", userCPU.Milliseconds()).
+			Int64("system_cpu_ms", systemCPU.Milliseconds())
+		if hasRSS {
+			e = e.Int64("max_rss_kb", maxRSS)
+		}
+	}
+	e.Msg("command finished")
+}
+
+// maskedArgv returns the command and its arguments with secrets masked.
+func (s *Shell) maskedArgv() []string {
+	argv := make([]string, 0, len(s.args)+1)
+	for _, arg := range append([]string{s.command}, s.args...) {
+		argv = append(argv, s.mask(arg))
+	}
+	return argv
+}
@@ -0,0 +1,79 @@
+package gosh
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// parseLogLines unmarshals every JSON log line in output.
+func parseLogLines(t *testing.T, output string) []map[string]any {
+	t.Helper()
+	var entries []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(output), "\n") {
+		if line == "" {
+			continue
+		}
+		var entry map[string]any
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("failed to unmarshal log line: %v\nLine was: %s", err, line)
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func TestLifecycleEvents(t *testing.T) {
+	ConfigureGlobals()
+
+	var err error
+	logOutput := captureOutput(func() {
+		err = New().
+			WithLifecycleEvents().
+			LogKV("deploymentId", "d1").
+			Command("sh").
+			Args("-c", "echo hello").
+			Stream()
+	})
+	if err != nil {
+		t.Fatalf("expected command to succeed, but it failed: %v", err)
+	}
+
+	entries := parseLogLines(t, logOutput)
+	if len(entries) != 3 {
+		t.Fatalf("expected started, output and finished entries, got %d: %s", len(entries), logOutput)
+	}
+
+	started, output, finished := entries[0], entries[1], entries[2]
+	if started["event"] != EventCommandStarted || started["deploymentId"] != "d1" {
+		t.Errorf("unexpected started event: %v", started)
+	}
+	if argv, _ := started["argv"].([]any); len(argv) != 3 || argv[0] != "sh" {
+		t.Errorf("expected argv in started event, got %v", started["argv"])
+	}
+	if pid, _ := started["pid"].(float64); pid == 0 {
+		t.Errorf("expected pid in started event, got %v", started["pid"])
+	}
+	if output["msg"] != "hello" || output["event"] != nil {
+		t.Errorf("expected plain output entry, got %v", output)
+	}
+	if finished["event"] != EventCommandFinished || finished["exit_code"] != float64(0) || finished["level"] != "info" {
+		t.Errorf("unexpected finished event: %v", finished)
+	}
+	if _, ok := finished["duration_ms"]; !ok {
+		t.Errorf("expected duration in finished event, got %v", finished)
+	}
+}
+
+func TestLifecycleFailedToStart(t *testing.T) {
+	ConfigureGlobals()
+
+	logOutput := captureOutput(func() {
+		New().WithLifecycleEvents().Arg("gosh-command-that-does-not-exist").Exec()
+	})
+
+	entries := parseLogLines(t, logOutput)
+	if len(entries) != 1 || entries[0]["event"] != EventCommandFailedToStart || entries[0]["level"] != "error" {
+		t.Fatalf("expected a single command_failed_to_start event, got %s", logOutput)
+	}
+}
@@ -0,0 +1,114 @@
+package gosh
+
+import (
+	"context"
+	"iter"
+	"sync"
+	"time"
+)
+
+// OutputStream names the stream a line of output was written to.
+type OutputStream string
+
+// Output streams of a Line.
+const (
+	Stdout OutputStream = "stdout"
+	Stderr OutputStream = "stderr"
+)
+
+// Line is a line of output of a streamed command.
+type Line struct {
+	// Stream is the stream the line was written to. Stderr merged with
+	// MergeStderr is reported as Stdout.
+	Stream OutputStream
+	// Text is the line without its trailing newline.
+	Text string
+	// Time is when the line was read from the command.
+	Time time.Time
+	// Seq numbers the lines of a run from 1, across both streams, in the
+	// order they were read.
+	Seq uint64
+	// Stage is the index of the pipeline stage that wrote a stderr line,
+	// or 0.
+	Stage int
+}
+
+// Lines runs the command like Stream and returns an iterator over its output
+// lines as they are produced. Lines are logged and sent to the sinks as
+// usual before they are yielded. If the command fails, the last pair yielded
+// has a zero Line and the error Stream would have returned.
+//
+// The command runs while the loop does, and a slow loop body slows the
+// command down once the output pipes fill up, much like a shell pipe.
+// Breaking out of the loop stops the command as if its context were
+// cancelled and waits for it to exit.
+//
+//	for line, err := range gosh.New().Command("docker").Args("build", ".").Lines() {
+//		if err != nil {
+//			return err
+//		}
+//		if id, ok := strings.CutPrefix(line.Text, "Successfully built "); ok {
+//			imageID = id
+//		}
+//	}
+func (s *Shell) Lines() iter.Seq2[Line, error] {
+	return s.LinesContext(context.Background())
+}
+
+// LinesContext is like Lines but stops the command if ctx is done before
+// it completes. Lines produced after that are logged but not yielded.
+func (s *Shell) LinesContext(ctx context.Context) iter.Seq2[Line, error] {
+	return func(yield func(Line, error) bool) {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		lines := make(chan Line)
+		done := make(chan error, 1)
+		go func() {
+			_, err := s.stream(ctx, func(line Line) {
+				select {
+				case lines <- line:
+				case <-ctx.Done():
+				}
+			})
+			done <- err
+		}()
+
+		for {
+			select {
+			case line := <-lines:
+				if !yield(line, nil) {
+					cancel()
+					<-done
+					return
+				}
+			case err := <-done:
+				// Every line has been received, as they are sent synchronously
+				if err != nil {
+					yield(Line{}, err)
+				}
+				return
+			}
+		}
+	}
+}
+
+// lineEmitter numbers the output lines of a run and passes them to fn.
+// Lines of both streams arrive concurrently; fn is called for one at a time,
+// in sequence order.
+type lineEmitter struct {
+	mutex sync.Mutex
+	seq   uint64
+	fn    func(Line)
+}
+
+// emit passes a line to fn. It is a no-op on a nil *lineEmitter.
+func (e *lineEmitter) emit(stream OutputStream, stage int, text string) {
+	if e == nil {
+		return
+	}
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.seq++
+	e.fn(Line{Stream: stream, Text: text, Time: time.Now(), Seq: e.seq, Stage: stage})
+}
@@ -0,0 +1,109 @@
+package gosh
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLines(t *testing.T) {
+	ring := NewRingBuffer(10)
+	var lines []Line
+	for line, err := range New().WithSinkOnly(ring).
+		Command("sh").Args("-c", "echo one; sleep 0.05; echo two >&2; sleep 0.05; echo three").
+		Lines() {
+		if err != nil {
+			t.Fatalf("expected command to succeed, but it failed: %v", err)
+		}
+		lines = append(lines, line)
+	}
+
+	want := []struct {
+		stream OutputStream
+		text   string
+	}{{Stdout, "one"}, {Stderr, "two"}, {Stdout, "three"}}
+	if len(lines) != len(want) {
+		t.Fatalf("expected %d lines, got %+v", len(want), lines)
+	}
+	for i, line := range lines {
+		if line.Stream != want[i].stream || line.Text != want[i].text || line.Seq != uint64(i+1) || line.Time.IsZero() {
+			t.Errorf("unexpected line %d: %+v", i, line)
+		}
+	}
+	if logged := ring.Lines(); len(logged) != 3 || !strings.Contains(logged[1], `"two"`) {
+		t.Errorf("expected lines to be logged too, got %q", logged)
+	}
+}
+
+func TestLinesError(t *testing.T) {
+	var texts []string
+	var err error
+	captureOutput(func() {
+		for line, lineErr := range New().Command("sh").Args("-c", "echo out; exit 3").Lines() {
+			if lineErr != nil {
+				err = lineErr
+				continue
+			}
+			texts = append(texts, line.Text)
+		}
+	})
+	var exitErr *ExitError
+	if !errors.As(err, &exitErr) || exitErr.ExitCode != 3 {
+		t.Errorf("expected an ExitError with code 3, got %v", err)
+	}
+	if len(texts) != 1 || texts[0] != "out" {
+		t.Errorf("expected the output before the error, got %q", texts)
+	}
+}
+
+func TestLinesBreak(t *testing.T) {
+	start := time.Now()
+	var first Line
+	captureOutput(func() {
+		for line := range New().GracePeriod(100*time.Millisecond).
+			Command("sh").Args("-c", "echo ready; echo more; sleep 10").
+			Lines() {
+			first = line
+			break
+		}
+	})
+	if first.Text != "ready" {
+		t.Errorf("expected the first line, got %+v", first)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("expected the command to be stopped, took %s", elapsed)
+	}
+}
+
+func TestLinesContextCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	var err error
+	captureOutput(func() {
+		for _, lineErr := range New().Command("echo").Args("hi").LinesContext(ctx) {
+			err = lineErr
+		}
+	})
+	if !errors.Is(err, ErrCancelled) {
+		t.Errorf("expected a cancelled error, got %v", err)
+	}
+}
+
+func TestLinesPipelineStage(t *testing.T) {
+	var stderr []Line
+	captureOutput(func() {
+		for line, err := range New().Command("echo").Args("data").
+			Pipe(New().Command("sh").Args("-c", "cat >/dev/null; echo warn >&2")).
+			Lines() {
+			if err == nil && line.Stream == Stderr {
+				stderr = append(stderr, line)
+			}
+		}
+	})
+	if len(stderr) != 1 || stderr[0].Stage != 1 || stderr[0].Text != "warn" {
+		t.Errorf("expected a stderr line from stage 1, got %+v", stderr)
+	}
+}
@@ -0,0 +1,260 @@
+package gosh
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// LokiFormat selects the body format of Loki push requests.
+type LokiFormat string
+
+const (
+	// LokiJSON sends push requests as JSON.
+	LokiJSON LokiFormat = "json"
+	// LokiProtobuf sends push requests as snappy-compressed protobuf, which
+	// is smaller and cheaper for Loki to parse.
+	LokiProtobuf LokiFormat = "protobuf"
+)
+
+// LokiOptions describes how log lines are mapped to Loki streams.
+type LokiOptions struct {
+	// Labels are static stream labels, such as job or host. If no labels
+	// are given at all, job="gosh" is used, as Loki rejects unlabelled streams.
+	Labels map[string]string
+	// LabelFields are the log fields that become stream labels, typically
+	// low-cardinality log KVs such as deploymentId, and possibly "level".
+	// Every other field except the message and timestamp, including
+	// high-cardinality ones such as seq or pid, is sent as structured metadata.
+	LabelFields []string
+	// Format is the body format; LokiJSON if empty.
+	Format LokiFormat
+	// TenantID is sent in the X-Scope-OrgID header for multi-tenant Loki.
+	TenantID string
+}
+
+// NewLokiSink returns an HTTPStreamWriter that pushes log lines to Loki's
+// push API at url, such as http://loki:3100/loki/api/v1/push, with the
+// usual batching, retries and spooling; streamOpts tune them. The message
+// of each line becomes the Loki log line and its timestamp the entry time.
+func NewLokiSink(url string, opts LokiOptions, streamOpts ...HTTPStreamOption) *HTTPStreamWriter {
+	headers := make(http.Header)
+	if opts.TenantID != "" {
+		headers.Set("X-Scope-OrgID", opts.TenantID)
+	}
+	enc := &lokiEncoder{opts: opts, last: make(map[string]int64)}
+	return NewHTTPStreamWriter(url, headers, append([]HTTPStreamOption{WithBatchEncoder(enc)}, streamOpts...)...)
+}
+
+// lokiEncoder is the BatchEncoder of NewLokiSink.
+type lokiEncoder struct {
+	opts LokiOptions
+
+	// Log timestamps have a resolution of seconds by default, so entries of
+	// a stream are given strictly increasing times to keep them in order.
+	mutex sync.Mutex
+	last  map[string]int64 // last timestamp per stream, in Unix nanoseconds
+}
+
+type lokiStream struct {
+	labels  map[string]string
+	key     string // labels in Prometheus text format
+	entries []lokiEntry
+}
+
+type lokiEntry struct {
+	ts       int64 // Unix nanoseconds
+	line     string
+	metadata [][2]string // name, value
+}
+
+// Encode implements BatchEncoder.
+func (e *lokiEncoder) Encode(batch []byte) ([]byte, string, error) {
+	var streams []*lokiStream
+	index := make(map[string]*lokiStream)
+	for _, line := range batchLines(batch) {
+		labels, entry := e.entry(line)
+		key := lokiLabelString(labels)
+		st := index[key]
+		if st == nil {
+			st = &lokiStream{labels: labels, key: key}
+			index[key] = st
+			streams = append(streams, st)
+		}
+		st.entries = append(st.entries, entry)
+	}
+
+	e.mutex.Lock()
+	for _, st := range streams {
+		for i := range st.entries {
+			if last := e.last[st.key]; st.entries[i].ts <= last {
+				st.entries[i].ts = last + 1
+			}
+			e.last[st.key] = st.entries[i].ts
+		}
+	}
+	e.mutex.Unlock()
+
+	if e.opts.Format == LokiProtobuf {
+		return snappyEncode(lokiProtobuf(streams)), "application/x-protobuf", nil
+	}
+	return lokiJSON(streams), "application/json", nil
+}
+
+// entry maps a log line to its stream labels and Loki entry.
+func (e *lokiEncoder) entry(line []byte) (map[string]string, lokiEntry) {
+	labels := make(map[string]string, len(e.opts.Labels)+len(e.opts.LabelFields))
+	for k, v := range e.opts.Labels {
+		labels[lokiLabelName(k)] = v
+	}
+	entry := lokiEntry{ts: time.Now().UnixNano(), line: string(line)}
+
+	fields, ok := parseRecord(line)
+	if ok {
+		if t, ok := recordTime(fields); ok {
+			entry.ts = t.UnixNano()
+		}
+		if msg, ok := fields[zerolog.MessageFieldName]; ok {
+			entry.line = fieldString(msg)
+		}
+		for _, name := range e.opts.LabelFields {
+			if v, ok := fields[name]; ok {
+				labels[lokiLabelName(name)] = fieldString(v)
+			}
+		}
+		for name, v := range fields {
+			if name == zerolog.MessageFieldName || name == zerolog.TimestampFieldName || slices.Contains(e.opts.LabelFields, name) {
+				continue
+			}
+			entry.metadata = append(entry.metadata, [2]string{name, fieldString(v)})
+		}
+		slices.SortFunc(entry.metadata, func(a, b [2]string) int { return strings.Compare(a[0], b[0]) })
+	}
+
+	if len(labels) == 0 {
+		labels["job"] = "gosh"
+	}
+	return labels, entry
+}
+
+// lokiLabelName replaces characters that are not allowed in label names.
+func lokiLabelName(name string) string {
+	b := []byte(name)
+	for i, c := range b {
+		valid := c == '_' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || i > 0 && '0' <= c && c <= '9'
+		if !valid {
+			b[i] = '_'
+		}
+	}
+	return string(b)
+}
+
+// lokiLabelString formats labels as a Prometheus label set, sorted by name.
+func lokiLabelString(labels map[string]string) string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteString(name)
+		b.WriteByte('=')
+		b.WriteString(strconv.Quote(labels[name]))
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+// lokiJSON encodes streams as a JSON push request.
+func lokiJSON(streams []*lokiStream) []byte {
+	type stream struct {
+		Stream map[string]string `json:"stream"`
+		Values [][]any           `json:"values"`
+	}
+	req := struct {
+		Streams []stream `json:"streams"`
+	}{}
+	for _, st := range streams {
+		s := stream{Stream: st.labels}
+		for _, entry := range st.entries {
+			value := []any{strconv.FormatInt(entry.ts, 10), entry.line}
+			if len(entry.metadata) > 0 {
+				metadata := make(map[string]string, len(entry.metadata))
+				for _, kv := range entry.metadata {
+					metadata[kv[0]] = kv[1]
+				}
+				value = append(value, metadata)
+			}
+			s.Values = append(s.Values, value)
+		}
+		req.Streams = append(req.Streams, s)
+	}
+	body, _ := json.Marshal(req)
+	return body
+}
+
+// lokiProtobuf encodes streams as a logproto.PushRequest:
+//
+//	message PushRequest { repeated StreamAdapter streams = 1; }
+//	message StreamAdapter { string labels = 1; repeated EntryAdapter entries = 2; }
+//	message EntryAdapter {
+//	  google.protobuf.Timestamp timestamp = 1;
+//	  string line = 2;
+//	  repeated LabelPairAdapter structuredMetadata = 3;
+//	}
+//	message LabelPairAdapter { string name = 1; string value = 2; }
+func lokiProtobuf(streams []*lokiStream) []byte {
+	var req []byte
+	for _, st := range streams {
+		var stream []byte
+		stream = protoAppendBytes(stream, 1, []byte(st.key))
+		for _, entry := range st.entries {
+			var ts []byte
+			ts = protoAppendVarint(ts, 1, uint64(entry.ts/int64(time.Second)))
+			ts = protoAppendVarint(ts, 2, uint64(entry.ts%int64(time.Second)))
+
+			var e []byte
+			e = protoAppendBytes(e, 1, ts)
+			e = protoAppendBytes(e, 2, []byte(entry.line))
+			for _, kv := range entry.metadata {
+				var pair []byte
+				pair = protoAppendBytes(pair, 1, []byte(kv[0]))
+				pair = protoAppendBytes(pair, 2, []byte(kv[1]))
+				e = protoAppendBytes(e, 3, pair)
+			}
+			stream = protoAppendBytes(stream, 2, e)
+		}
+		req = protoAppendBytes(req, 1, stream)
+	}
+	return req
+}
+
+// protoAppendVarint appends a varint field, omitting zero values as proto3 does.
+func protoAppendVarint(b []byte, field int, v uint64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = binary.AppendUvarint(b, uint64(field)<<3)
+	return binary.AppendUvarint(b, v)
+}
+
+// protoAppendBytes appends a length-delimited field: a string, bytes or an
+// embedded message.
+func protoAppendBytes(b []byte, field int, v []byte) []byte {
+	b = binary.AppendUvarint(b, uint64(field)<<3|2)
+	b = binary.AppendUvarint(b, uint64(len(v)))
+	return append(b, v...)
+}
@@ -0,0 +1,245 @@
+package gosh
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+// lokiPush is a decoded push request, independent of the wire format.
+type lokiPush struct {
+	labels   string
+	line     string
+	ts       int64
+	metadata map[string]string
+}
+
+// newLokiServer starts a stand-in for Loki's push API that checks the
+// request shape for the given format and records every entry.
+func newLokiServer(t *testing.T, format LokiFormat) (*httptest.Server, func() []lokiPush) {
+	t.Helper()
+	var mu sync.Mutex
+	var entries []lokiPush
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if r.URL.Path != "/loki/api/v1/push" || r.Header.Get("X-Scope-OrgID") != "team-a" {
+			http.Error(w, "bad path or tenant", http.StatusBadRequest)
+			return
+		}
+
+		var pushed []lokiPush
+		var err error
+		switch format {
+		case LokiJSON:
+			if r.Header.Get("Content-Type") != "application/json" {
+				err = fmt.Errorf("unexpected content type %q", r.Header.Get("Content-Type"))
+				break
+			}
+			pushed, err = decodeLokiJSON(body)
+		case LokiProtobuf:
+			if r.Header.Get("Content-Type") != "application/x-protobuf" {
+				err = fmt.Errorf("unexpected content type %q", r.Header.Get("Content-Type"))
+				break
+			}
+			var raw []byte
+			if raw, err = snappyDecode(body); err == nil {
+				pushed, err = decodeLokiProtobuf(raw)
+			}
+		}
+		if err != nil {
+			t.Errorf("invalid push request: %v", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		entries = append(entries, pushed...)
+		mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, func() []lokiPush {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]lokiPush(nil), entries...)
+	}
+}
+
+func decodeLokiJSON(body []byte) ([]lokiPush, error) {
+	var req struct {
+		Streams []struct {
+			Stream map[string]string   `json:"stream"`
+			Values [][]json.RawMessage `json:"values"`
+		} `json:"streams"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil, err
+	}
+	var pushed []lokiPush
+	for _, st := range req.Streams {
+		for _, v := range st.Values {
+			if len(v) < 2 || len(v) > 3 {
+				return nil, fmt.Errorf("expected 2 or 3 values per entry, got %d", len(v))
+			}
+			var p lokiPush
+			var ts string
+			if err := json.Unmarshal(v[0], &ts); err != nil {
+				return nil, fmt.Errorf("timestamp must be a string: %w", err)
+			}
+			p.ts, _ = strconv.ParseInt(ts, 10, 64)
+			json.Unmarshal(v[1], &p.line)
+			if len(v) == 3 {
+				if err := json.Unmarshal(v[2], &p.metadata); err != nil {
+					return nil, fmt.Errorf("structured metadata must be an object of strings: %w", err)
+				}
+			}
+			p.labels = lokiLabelString(st.Stream)
+			pushed = append(pushed, p)
+		}
+	}
+	return pushed, nil
+}
+
+// protoFields splits a protobuf message into its fields, keyed by number.
+func protoFields(b []byte) (map[int][][]byte, map[int]uint64, error) {
+	bytesFields := make(map[int][][]byte)
+	varints := make(map[int]uint64)
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		if n <= 0 {
+			return nil, nil, fmt.Errorf("bad field key")
+		}
+		b = b[n:]
+		field := int(key >> 3)
+		switch key & 7 {
+		case 0:
+			v, n := binary.Uvarint(b)
+			if n <= 0 {
+				return nil, nil, fmt.Errorf("bad varint")
+			}
+			varints[field] = v
+			b = b[n:]
+		case 2:
+			l, n := binary.Uvarint(b)
+			if n <= 0 || uint64(len(b)-n) < l {
+				return nil, nil, fmt.Errorf("bad length")
+			}
+			bytesFields[field] = append(bytesFields[field], b[n:n+int(l)])
+			b = b[n+int(l):]
+		default:
+			return nil, nil, fmt.Errorf("unexpected wire type %d", key&7)
+		}
+	}
+	return bytesFields, varints, nil
+}
+
+func decodeLokiProtobuf(body []byte) ([]lokiPush, error) {
+	req, _, err := protoFields(body)
+	if err != nil {
+		return nil, err
+	}
+	var pushed []lokiPush
+	for _, stream := range req[1] {
+		st, _, err := protoFields(stream)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range st[2] {
+			e, _, err := protoFields(entry)
+			if err != nil {
+				return nil, err
+			}
+			_, ts, err := protoFields(e[1][0])
+			if err != nil {
+				return nil, err
+			}
+			p := lokiPush{
+				labels:   string(st[1][0]),
+				line:     string(e[2][0]),
+				ts:       int64(ts[1])*1e9 + int64(ts[2]),
+				metadata: map[string]string{},
+			}
+			for _, pair := range e[3] {
+				kv, _, err := protoFields(pair)
+				if err != nil {
+					return nil, err
+				}
+				p.metadata[string(kv[1][0])] = string(kv[2][0])
+			}
+			pushed = append(pushed, p)
+		}
+	}
+	return pushed, nil
+}
+
+func TestLokiSink(t *testing.T) {
+	ConfigureGlobals()
+
+	for _, format := range []LokiFormat{LokiJSON, LokiProtobuf} {
+		t.Run(string(format), func(t *testing.T) {
+			srv, entries := newLokiServer(t, format)
+
+			sink := NewLokiSink(srv.URL+"/loki/api/v1/push", LokiOptions{
+				Labels:      map[string]string{"job": "builds"},
+				LabelFields: []string{"deploymentId", "level"},
+				Format:      format,
+				TenantID:    "team-a",
+			})
+			err := New().
+				WithSinkOnly(sink).
+				LogKV("deploymentId", "d1").
+				LogKV("commit", "a1b2c3").
+				Command("sh").
+				Args("-c", "echo one; echo two; echo oops >&2").
+				Stream()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := entries()
+			if len(got) != 3 {
+				t.Fatalf("expected 3 entries, got %d: %+v", len(got), got)
+			}
+			info := `{deploymentId="d1", job="builds", level="info"}`
+			var infoLines []string
+			var lastTS int64
+			for _, e := range got {
+				if e.metadata["commit"] != "a1b2c3" || e.metadata["seq"] == "" {
+					t.Errorf("expected commit and seq as structured metadata, got %v", e.metadata)
+				}
+				if _, ok := e.metadata["deploymentId"]; ok {
+					t.Errorf("expected label fields not to be repeated as metadata, got %v", e.metadata)
+				}
+				if e.labels == info {
+					if e.ts <= lastTS {
+						t.Errorf("expected strictly increasing timestamps within a stream")
+					}
+					lastTS = e.ts
+					infoLines = append(infoLines, e.line)
+				} else if e.labels != `{deploymentId="d1", job="builds", level="error"}` || e.line != "oops" {
+					t.Errorf("unexpected entry %+v", e)
+				}
+			}
+			if fmt.Sprint(infoLines) != "[one two]" {
+				t.Errorf("expected stdout lines in order in the info stream, got %q", infoLines)
+			}
+		})
+	}
+}
+
+func TestLokiSinkPlainLines(t *testing.T) {
+	enc := &lokiEncoder{last: make(map[string]int64)}
+	body, _, _ := enc.Encode([]byte("not json\n"))
+	pushed, err := decodeLokiJSON(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pushed) != 1 || pushed[0].line != "not json" || pushed[0].labels != `{job="gosh"}` {
+		t.Errorf("expected the raw line with the default label, got %+v", pushed)
+	}
+}
@@ -0,0 +1,181 @@
+package gosh
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+)
+
+var (
+	// ErrAborted matches errors for commands stopped because their output
+	// matched an AbortOn pattern.
+	ErrAborted = errors.New("command aborted")
+	// ErrNoMatch matches errors for commands whose output did not match an
+	// Expect pattern.
+	ErrNoMatch = errors.New("expected output not found")
+)
+
+// Match is a line of output that matched an OnMatch, AbortOn or Expect pattern.
+type Match struct {
+	// Pattern is the regular expression that matched.
+	Pattern *regexp.Regexp
+	// Line is the matching line.
+	Line Line
+	// Groups holds the text of the leftmost match and its submatches, as
+	// returned by Regexp.FindStringSubmatch.
+	Groups []string
+}
+
+// Group returns the text of the named submatch, or "" if the pattern has no
+// such group or it did not participate in the match.
+func (m Match) Group(name string) string {
+	if i := m.Pattern.SubexpIndex(name); i >= 0 && i < len(m.Groups) {
+		return m.Groups[i]
+	}
+	return ""
+}
+
+// AbortError is returned when a command is stopped because its output
+// matched an AbortOn pattern. It matches ErrAborted with errors.Is.
+type AbortError struct {
+	Match Match
+	// Outcome is OutcomeTerminated or OutcomeKilled, or empty if the
+	// command exited on its own before it could be stopped.
+	Outcome Outcome
+}
+
+func (e *AbortError) Error() string {
+	msg := fmt.Sprintf("command aborted: output matched %q: %s", e.Match.Pattern, e.Match.Line.Text)
+	if e.Outcome != "" {
+		msg += " (" + string(e.Outcome) + ")"
+	}
+	return msg
+}
+
+func (e *AbortError) Is(target error) bool { return target == ErrAborted }
+
+// OnLine calls fn for every line of output as it is produced, with Exec as
+// well as Stream. Calls are made one at a time, in Line.Seq order, and the
+// command's output is held up while fn runs, so fn should return quickly.
+func (s *Shell) OnLine(fn func(Line)) *Shell {
+	s.onLine = append(s.onLine, fn)
+	return s
+}
+
+// OnMatch calls fn for every line of output that matches re, as it is
+// produced. Matches are also collected in Result.Matches, so fn may be nil
+// to just capture values such as image digests.
+func (s *Shell) OnMatch(re *regexp.Regexp, fn func(Match)) *Shell {
+	s.matchers = append(s.matchers, matcher{re: re, fn: fn})
+	return s
+}
+
+// AbortOn stops the command as soon as a line of output matches re, such
+// as a known fatal error, with SIGTERM and, after the grace period, SIGKILL.
+// The command then returns an *AbortError, and the match is collected in
+// Result.Matches.
+func (s *Shell) AbortOn(re *regexp.Regexp) *Shell {
+	s.matchers = append(s.matchers, matcher{re: re, abort: true})
+	return s
+}
+
+// Expect makes a command that succeeds fail with an error matching
+// ErrNoMatch unless a line of its output matches re. Matches are collected
+// in Result.Matches.
+func (s *Shell) Expect(re *regexp.Regexp) *Shell {
+	s.matchers = append(s.matchers, matcher{re: re, expect: true})
+	return s
+}
+
+// matcher is a pattern registered with OnMatch, AbortOn or Expect.
+type matcher struct {
+	re     *regexp.Regexp
+	fn     func(Match)
+	abort  bool
+	expect bool
+}
+
+// outputWatcher runs the line hooks and matchers of a run.
+type outputWatcher struct {
+	s       *Shell
+	onLine  func(Line) // internal hook, such as that of Lines
+	abort   context.CancelCauseFunc
+	matches []Match
+	matched []bool // per matcher
+	aborted *AbortError
+}
+
+// newOutputWatcher returns the watcher of a run, or nil if nothing watches
+// the output. abort cancels the run's context.
+func (s *Shell) newOutputWatcher(onLine func(Line), abort context.CancelCauseFunc) *outputWatcher {
+	if onLine == nil && len(s.onLine) == 0 && len(s.matchers) == 0 {
+		return nil
+	}
+	return &outputWatcher{s: s, onLine: onLine, abort: abort, matched: make([]bool, len(s.matchers))}
+}
+
+// emitter returns the lineEmitter that feeds the watcher, or nil for a nil watcher.
+func (w *outputWatcher) emitter() *lineEmitter {
+	if w == nil {
+		return nil
+	}
+	return &lineEmitter{fn: w.line}
+}
+
+// line runs the hooks and matchers for a line of output.
+func (w *outputWatcher) line(line Line) {
+	for _, fn := range w.s.onLine {
+		fn(line)
+	}
+	for i, m := range w.s.matchers {
+		groups := m.re.FindStringSubmatch(line.Text)
+		if groups == nil {
+			continue
+		}
+		match := Match{Pattern: m.re, Line: line, Groups: groups}
+		w.matches = append(w.matches, match)
+		w.matched[i] = true
+		if m.fn != nil {
+			m.fn(match)
+		}
+		if m.abort && w.aborted == nil {
+			w.aborted = &AbortError{Match: match}
+			w.abort(w.aborted)
+		}
+	}
+	if w.onLine != nil {
+		w.onLine(line)
+	}
+}
+
+// finish records the matches in res and returns the error of the run,
+// accounting for aborts and unmet expectations.
+func (w *outputWatcher) finish(res *Result, err error) error {
+	if w == nil {
+		return err
+	}
+	res.Matches = w.matches
+	if err != nil {
+		return err
+	}
+	if w.aborted != nil {
+		// The command exited before it could be stopped
+		return w.aborted
+	}
+	for i, m := range w.s.matchers {
+		if m.expect && !w.matched[i] {
+			return fmt.Errorf("%w: %s", ErrNoMatch, m.re)
+		}
+	}
+	return nil
+}
+
+// abortCause returns the *AbortError that ctx was cancelled with, or nil.
+func abortCause(ctx context.Context) *AbortError {
+	var abort *AbortError
+	if errors.As(context.Cause(ctx), &abort) {
+		return abort
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+package gosh
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOnLine(t *testing.T) {
+	var lines []Line
+	var err error
+	captureOutput(func() {
+		_, err = New().
+			OnLine(func(line Line) { lines = append(lines, line) }).
+			Command("sh").Args("-c", "echo one; sleep 0.05; echo two >&2").
+			Exec()
+	})
+	if err != nil {
+		t.Fatalf("expected command to succeed, but it failed: %v", err)
+	}
+	if len(lines) != 2 || lines[0].Text != "one" || lines[1].Stream != Stderr || lines[1].Seq != 2 {
+		t.Errorf("expected both lines in order, got %+v", lines)
+	}
+}
+
+func TestOnMatch(t *testing.T) {
+	digestRE := regexp.MustCompile(`digest: (?P<digest>sha256:[0-9a-f]+)`)
+	for _, mode := range []string{"exec", "stream"} {
+		t.Run(mode, func(t *testing.T) {
+			var called []string
+			var res *Result
+			var err error
+			captureOutput(func() {
+				s := New().
+					OnMatch(digestRE, func(m Match) { called = append(called, m.Group("digest")) }).
+					Command("sh").Args("-c", "echo pushing; echo 'latest: digest: sha256:abc123 size: 42'")
+				if mode == "exec" {
+					res, err = s.ExecResult()
+				} else {
+					res, err = s.StreamResult()
+				}
+			})
+			if err != nil {
+				t.Fatalf("expected command to succeed, but it failed: %v", err)
+			}
+			if len(res.Matches) != 1 || res.Matches[0].Group("digest") != "sha256:abc123" || res.Matches[0].Line.Seq != 2 {
+				t.Errorf("expected the digest in Result.Matches, got %+v", res.Matches)
+			}
+			if len(called) != 1 || called[0] != "sha256:abc123" {
+				t.Errorf("expected the callback to get the digest, got %q", called)
+			}
+		})
+	}
+}
+
+func TestAbortOn(t *testing.T) {
+	ConfigureGlobals()
+
+	for _, mode := range []string{"exec", "stream"} {
+		t.Run(mode, func(t *testing.T) {
+			start := time.Now()
+			var res *Result
+			var err error
+			logOutput := captureOutput(func() {
+				s := New().
+					AbortOn(regexp.MustCompile(`no space left on device`)).
+					Command("sh").Args("-c", "echo start; echo 'write /tmp/x: no space left on device' >&2; sleep 10; echo never")
+				if mode == "exec" {
+					res, err = s.ExecResult()
+				} else {
+					res, err = s.StreamResult()
+				}
+			})
+
+			var abortErr *AbortError
+			if !errors.Is(err, ErrAborted) || !errors.As(err, &abortErr) {
+				t.Fatalf("expected an AbortError, got %v", err)
+			}
+			if abortErr.Outcome != OutcomeTerminated || abortErr.Match.Line.Stream != Stderr {
+				t.Errorf("unexpected abort: %+v", abortErr)
+			}
+			if elapsed := time.Since(start); elapsed > 5*time.Second {
+				t.Errorf("expected the command to be stopped early, took %s", elapsed)
+			}
+			if len(res.Matches) != 1 || strings.Contains(string(res.Stdout)+logOutput, "never") {
+				t.Errorf("unexpected result: %+v", res)
+			}
+			if !strings.Contains(logOutput, `"event":"aborted"`) {
+				t.Errorf("expected an aborted event, got %s", logOutput)
+			}
+		})
+	}
+}
+
+func TestAbortOnExitedCommand(t *testing.T) {
+	var err error
+	captureOutput(func() {
+		_, err = New().AbortOn(regexp.MustCompile(`fatal`)).Command("echo").Args("fatal error").Exec()
+	})
+	if !errors.Is(err, ErrAborted) {
+		t.Errorf("expected an aborted error even if the command exited first, got %v", err)
+	}
+}
+
+func TestExpect(t *testing.T) {
+	tests := []struct {
+		name    string
+		output  string
+		wantErr bool
+	}{
+		{"found", "Successfully built 1234abcd", false},
+		{"missing", "Build failed quietly", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var res *Result
+			var err error
+			captureOutput(func() {
+				res, err = New().
+					Expect(regexp.MustCompile(`^Successfully built (\w+)$`)).
+					Command("echo").Args(tt.output).
+					StreamResult()
+			})
+			if errors.Is(err, ErrNoMatch) != tt.wantErr {
+				t.Fatalf("expected ErrNoMatch %v, got %v", tt.wantErr, err)
+			}
+			if !tt.wantErr && res.Matches[0].Groups[1] != "1234abcd" {
+				t.Errorf("expected the build ID, got %+v", res.Matches)
+			}
+		})
+	}
+}
@@ -0,0 +1,402 @@
+package gosh
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// TraceParentEnv is the environment variable that carries W3C trace context
+// between processes.
+const TraceParentEnv = "TRACEPARENT"
+
+// Log fields that correlate log lines with the span of their command.
+const (
+	TraceIDField = "trace_id"
+	SpanIDField  = "span_id"
+)
+
+// ErrInvalidTraceParent is returned by ParseTraceParent for malformed values.
+var ErrInvalidTraceParent = errors.New("invalid traceparent")
+
+// SpanContext identifies a span in a W3C trace.
+type SpanContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+	Sampled bool
+}
+
+// IsValid reports whether the trace and span IDs are set.
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != [16]byte{} && sc.SpanID != [8]byte{}
+}
+
+// TraceParent formats sc as a W3C traceparent header value.
+func (sc SpanContext) TraceParent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return "00-" + hex.EncodeToString(sc.TraceID[:]) + "-" + hex.EncodeToString(sc.SpanID[:]) + "-" + flags
+}
+
+// ParseTraceParent parses a W3C traceparent header value, such as the
+// TRACEPARENT environment variable set by a CI system.
+func ParseTraceParent(value string) (SpanContext, error) {
+	var sc SpanContext
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) {
+		return sc, fmt.Errorf("%w: %q", ErrInvalidTraceParent, value)
+	}
+	trace, err1 := hex.DecodeString(parts[1])
+	span, err2 := hex.DecodeString(parts[2])
+	flags, err3 := hex.DecodeString(parts[3])
+	if err1 != nil || err2 != nil || err3 != nil || len(trace) != 16 || len(span) != 8 || len(flags) != 1 {
+		return sc, fmt.Errorf("%w: %q", ErrInvalidTraceParent, value)
+	}
+	copy(sc.TraceID[:], trace)
+	copy(sc.SpanID[:], span)
+	sc.Sampled = flags[0]&1 == 1
+	if !sc.IsValid() {
+		return sc, fmt.Errorf("%w: %q", ErrInvalidTraceParent, value)
+	}
+	return sc, nil
+}
+
+type spanContextKey struct{}
+
+// ContextWithSpanContext returns a copy of ctx carrying sc, which commands
+// run with ctx use as their parent span.
+func ContextWithSpanContext(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, spanContextKey{}, sc)
+}
+
+// SpanContextFromContext returns the span context carried by ctx, if any.
+func SpanContextFromContext(ctx context.Context) (SpanContext, bool) {
+	sc, ok := ctx.Value(spanContextKey{}).(SpanContext)
+	return sc, ok && sc.IsValid()
+}
+
+// OTLPOptions configures the export of spans and log records to an
+// OpenTelemetry collector over OTLP/HTTP.
+type OTLPOptions struct {
+	// Endpoint is the base URL of the receiver, such as
+	// http://localhost:4318. Spans are posted to /v1/traces and log
+	// records to /v1/logs under it.
+	Endpoint string
+	// ServiceName is the service.name resource attribute; "gosh" if empty.
+	ServiceName string
+	// StreamOptions tune the HTTPStreamWriters of both signals, for example
+	// with WithTokenProvider. Since they are applied to two writers, they
+	// must not include WithSpool.
+	StreamOptions []HTTPStreamOption
+}
+
+// WithOTLP exports every Exec or Stream as an OpenTelemetry span and its
+// log lines as OTLP log records. The span carries the command, its
+// arguments with secrets masked, its exit code and the log KVs as
+// attributes, and log records carry its trace and span IDs.
+//
+// The span's parent is taken from the context passed to ExecContext or
+// StreamContext (see ContextWithSpanContext), or else from TRACEPARENT in
+// the environment. The command gets the span as TRACEPARENT, so that
+// instrumented children join the trace.
+func (s *Shell) WithOTLP(opts OTLPOptions) *Shell {
+	endpoint := strings.TrimSuffix(opts.Endpoint, "/")
+	service := opts.ServiceName
+	if service == "" {
+		service = "gosh"
+	}
+
+	traceOpts := append([]HTTPStreamOption{WithBatchEncoder(&otlpSpanEncoder{service: service})}, opts.StreamOptions...)
+	s.traces = NewHTTPStreamWriter(endpoint+"/v1/traces", nil, traceOpts...)
+
+	logOpts := append([]HTTPStreamOption{WithBatchEncoder(&otlpLogEncoder{service: service})}, opts.StreamOptions...)
+	return s.WithSink(NewHTTPStreamWriter(endpoint+"/v1/logs", nil, logOpts...))
+}
+
+// span is the span of the command being run.
+type span struct {
+	SpanContext
+	parent SpanContext
+	start  time.Time
+}
+
+// startSpan starts the span of a run if spans are exported, and otherwise
+// records the parent trace context, if any, to pass on to the command.
+func (s *Shell) startSpan(ctx context.Context) {
+	parent, ok := SpanContextFromContext(ctx)
+	if !ok {
+		parent, _ = ParseTraceParent(s.envTraceParent())
+	}
+	s.span = nil
+	s.traceParent = ""
+	if parent.IsValid() {
+		s.traceParent = parent.TraceParent()
+	}
+	if s.traces == nil {
+		return
+	}
+
+	sp := &span{parent: parent, start: time.Now()}
+	sp.TraceID = parent.TraceID
+	if !parent.IsValid() {
+		rand.Read(sp.TraceID[:])
+		sp.Sampled = true
+	} else {
+		sp.Sampled = parent.Sampled
+	}
+	rand.Read(sp.SpanID[:])
+	s.span = sp
+	s.traceParent = sp.TraceParent()
+}
+
+// envTraceParent returns TRACEPARENT as set with Env, or else as inherited
+// from this process.
+func (s *Shell) envTraceParent() string {
+	for i := len(s.env) - 1; i >= 0; i-- {
+		if value, ok := strings.CutPrefix(s.env[i], TraceParentEnv+"="); ok {
+			return value
+		}
+	}
+	return os.Getenv(TraceParentEnv)
+}
+
+// endSpan queues the span of the finished run, if any, for export.
+func (s *Shell) endSpan(res *Result, err error) {
+	sp := s.span
+	s.span = nil
+	if sp == nil {
+		return
+	}
+
+	end := time.Now()
+	attrs := []otlpKeyValue{
+		otlpAttr("process.command", s.command),
+		{Key: "process.command_args", Value: otlpArray(s.maskedArgv())},
+	}
+	if res != nil && res.PID != 0 {
+		attrs = append(attrs,
+			otlpAttr("process.pid", json.Number(strconv.Itoa(res.PID))),
+			otlpAttr("process.exit.code", json.Number(strconv.Itoa(res.ExitCode))))
+		end = res.EndTime
+	}
+	for k, v := range s.logKVs {
+		attrs = append(attrs, otlpAttr(k, v))
+	}
+
+	status := otlpStatus{Code: 1} // STATUS_CODE_OK
+	if err != nil {
+		status = otlpStatus{Code: 2, Message: s.mask(err.Error())} // STATUS_CODE_ERROR
+	}
+	record := otlpSpan{
+		TraceID:           hex.EncodeToString(sp.TraceID[:]),
+		SpanID:            hex.EncodeToString(sp.SpanID[:]),
+		Name:              s.command,
+		Kind:              1, // SPAN_KIND_INTERNAL
+		StartTimeUnixNano: strconv.FormatInt(sp.start.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(end.UnixNano(), 10),
+		Attributes:        attrs,
+		Status:            status,
+	}
+	if sp.parent.IsValid() {
+		record.ParentSpanID = hex.EncodeToString(sp.parent.SpanID[:])
+	}
+	line, _ := json.Marshal(record)
+	s.traces.Write(append(line, '\n'))
+}
+
+// withTraceContext adds the trace and span IDs of the running command to a
+// log event.
+func (s *Shell) withTraceContext(e *zerolog.Event) *zerolog.Event {
+	if s.span == nil {
+		return e
+	}
+	return e.Hex(TraceIDField, s.span.TraceID[:]).Hex(SpanIDField, s.span.SpanID[:])
+}
+
+// closeTraces flushes exported spans and records whether they were delivered.
+func (s *Shell) closeTraces() {
+	if s.traces == nil {
+		return
+	}
+	if err := s.traces.Close(); err != nil {
+		s.deliveryErr = errors.Join(s.deliveryErr, &SinkError{Sink: s.traces, Err: err})
+	}
+}
+
+// OTLP/HTTP JSON encoding (https://opentelemetry.io/docs/specs/otlp/#json-protobuf-encoding).
+// IDs are hex strings and 64-bit integers are decimal strings.
+
+type otlpKeyValue struct {
+	Key   string         `json:"key"`
+	Value map[string]any `json:"value"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              int            `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Status            otlpStatus     `json:"status"`
+}
+
+type otlpLogRecord struct {
+	TimeUnixNano         string         `json:"timeUnixNano"`
+	ObservedTimeUnixNano string         `json:"observedTimeUnixNano"`
+	SeverityNumber       int            `json:"severityNumber,omitempty"`
+	SeverityText         string         `json:"severityText,omitempty"`
+	Body                 map[string]any `json:"body"`
+	Attributes           []otlpKeyValue `json:"attributes,omitempty"`
+	TraceID              string         `json:"traceId,omitempty"`
+	SpanID               string         `json:"spanId,omitempty"`
+}
+
+// otlpAttr converts a log field value to an OTLP attribute.
+func otlpAttr(key string, v any) otlpKeyValue {
+	return otlpKeyValue{Key: key, Value: otlpAnyValue(v)}
+}
+
+func otlpAnyValue(v any) map[string]any {
+	switch v := v.(type) {
+	case string:
+		return map[string]any{"stringValue": v}
+	case bool:
+		return map[string]any{"boolValue": v}
+	case json.Number:
+		if _, err := v.Int64(); err == nil {
+			return map[string]any{"intValue": v.String()}
+		}
+		f, _ := v.Float64()
+		return map[string]any{"doubleValue": f}
+	}
+	return map[string]any{"stringValue": fieldString(v)}
+}
+
+func otlpArray(values []string) map[string]any {
+	items := make([]map[string]any, len(values))
+	for i, v := range values {
+		items[i] = otlpAnyValue(v)
+	}
+	return map[string]any{"arrayValue": map[string]any{"values": items}}
+}
+
+// otlpResource returns the resource of exported telemetry.
+func otlpResource(service string) map[string]any {
+	return map[string]any{"attributes": []otlpKeyValue{otlpAttr("service.name", service)}}
+}
+
+// otlpScope is the instrumentation scope of exported telemetry.
+var otlpScope = map[string]string{"name": "github.com/sanchitrk/gosh"}
+
+// otlpSpanEncoder is the BatchEncoder for spans queued by endSpan.
+type otlpSpanEncoder struct {
+	service string
+}
+
+// Encode implements BatchEncoder.
+func (e *otlpSpanEncoder) Encode(batch []byte) ([]byte, string, error) {
+	var spans []json.RawMessage
+	for _, line := range batchLines(batch) {
+		var sp map[string]json.RawMessage
+		if json.Unmarshal(line, &sp) != nil {
+			continue
+		}
+		// Drop the sequence number added by the HTTPStreamWriter, which is not part of a span
+		delete(sp, "seq")
+		b, _ := json.Marshal(sp)
+		spans = append(spans, b)
+	}
+	body, _ := json.Marshal(map[string]any{
+		"resourceSpans": []map[string]any{{
+			"resource":   otlpResource(e.service),
+			"scopeSpans": []map[string]any{{"scope": otlpScope, "spans": spans}},
+		}},
+	})
+	return body, "application/json", nil
+}
+
+// otlpLogEncoder is the BatchEncoder for log records.
+type otlpLogEncoder struct {
+	service string
+}
+
+// Encode implements BatchEncoder.
+func (e *otlpLogEncoder) Encode(batch []byte) ([]byte, string, error) {
+	observed := strconv.FormatInt(time.Now().UnixNano(), 10)
+	records := []otlpLogRecord{}
+	for _, line := range batchLines(batch) {
+		record := otlpLogRecord{
+			TimeUnixNano:         observed,
+			ObservedTimeUnixNano: observed,
+			Body:                 otlpAnyValue(string(line)),
+		}
+		fields, ok := parseRecord(line)
+		if ok {
+			if t, ok := recordTime(fields); ok {
+				record.TimeUnixNano = strconv.FormatInt(t.UnixNano(), 10)
+			}
+			if msg, ok := fields[zerolog.MessageFieldName]; ok {
+				record.Body = otlpAnyValue(msg)
+			}
+			if level, ok := fields[zerolog.LevelFieldName].(string); ok {
+				record.SeverityText = strings.ToUpper(level)
+				record.SeverityNumber = otlpSeverity(level)
+			}
+			record.TraceID, _ = fields[TraceIDField].(string)
+			record.SpanID, _ = fields[SpanIDField].(string)
+			for k, v := range fields {
+				switch k {
+				case zerolog.MessageFieldName, zerolog.TimestampFieldName, zerolog.LevelFieldName, TraceIDField, SpanIDField:
+					continue
+				}
+				record.Attributes = append(record.Attributes, otlpAttr(k, v))
+			}
+		}
+		records = append(records, record)
+	}
+	body, _ := json.Marshal(map[string]any{
+		"resourceLogs": []map[string]any{{
+			"resource":  otlpResource(e.service),
+			"scopeLogs": []map[string]any{{"scope": otlpScope, "logRecords": records}},
+		}},
+	})
+	return body, "application/json", nil
+}
+
+// otlpSeverity maps a zerolog level to an OTLP severity number.
+func otlpSeverity(level string) int {
+	switch level {
+	case zerolog.LevelTraceValue:
+		return 1
+	case zerolog.LevelDebugValue:
+		return 5
+	case zerolog.LevelInfoValue:
+		return 9
+	case zerolog.LevelWarnValue:
+		return 13
+	case zerolog.LevelErrorValue:
+		return 17
+	case zerolog.LevelFatalValue, zerolog.LevelPanicValue:
+		return 21
+	}
+	return 0
+}
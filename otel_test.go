@@ -0,0 +1,210 @@
+package gosh
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// otlpReceiver is a stand-in for an OTLP/HTTP collector that decodes the
+// JSON encoding of spans and log records.
+type otlpReceiver struct {
+	*httptest.Server
+	mu    sync.Mutex
+	spans []map[string]any
+	logs  []map[string]any
+}
+
+func newOTLPReceiver(t *testing.T) *otlpReceiver {
+	t.Helper()
+	rcv := &otlpReceiver{}
+	rcv.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if r.Header.Get("Content-Type") != "application/json" {
+			http.Error(w, "unsupported content type", http.StatusUnsupportedMediaType)
+			return
+		}
+		var req struct {
+			ResourceSpans []struct {
+				Resource   map[string]any
+				ScopeSpans []struct {
+					Spans []map[string]any
+				}
+			}
+			ResourceLogs []struct {
+				Resource  map[string]any
+				ScopeLogs []struct {
+					LogRecords []map[string]any
+				}
+			}
+		}
+		if err := json.Unmarshal(body, &req); err != nil {
+			t.Errorf("invalid OTLP JSON: %v", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		rcv.mu.Lock()
+		defer rcv.mu.Unlock()
+		switch r.URL.Path {
+		case "/v1/traces":
+			for _, rs := range req.ResourceSpans {
+				for _, ss := range rs.ScopeSpans {
+					rcv.spans = append(rcv.spans, ss.Spans...)
+				}
+			}
+		case "/v1/logs":
+			for _, rl := range req.ResourceLogs {
+				for _, sl := range rl.ScopeLogs {
+					rcv.logs = append(rcv.logs, sl.LogRecords...)
+				}
+			}
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(rcv.Close)
+	return rcv
+}
+
+// attributes flattens OTLP attributes into a map of their JSON values.
+func attributes(v any) map[string]string {
+	attrs := make(map[string]string)
+	list, _ := v.([]any)
+	for _, a := range list {
+		kv := a.(map[string]any)
+		value, _ := json.Marshal(kv["value"])
+		attrs[kv["key"].(string)] = string(value)
+	}
+	return attrs
+}
+
+func TestOTLPSpanAndCorrelatedLogs(t *testing.T) {
+	ConfigureGlobals()
+	rcv := newOTLPReceiver(t)
+
+	parent, _ := ParseTraceParent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	ctx := ContextWithSpanContext(context.Background(), parent)
+
+	var out string
+	var err error
+	captureOutput(func() {
+		out, err = New().
+			WithOTLP(OTLPOptions{Endpoint: rcv.URL, ServiceName: "builder"}).
+			LogKV("deploymentId", "d1").
+			Command("sh").
+			Args("-c", "echo $TRACEPARENT; echo failing >&2; exit 3").
+			ExecContext(ctx)
+	})
+	var exitErr *ExitError
+	if !errors.As(err, &exitErr) {
+		t.Fatalf("expected an *ExitError, got %v", err)
+	}
+
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+	if len(rcv.spans) != 1 {
+		t.Fatalf("expected 1 span, got %d", len(rcv.spans))
+	}
+	span := rcv.spans[0]
+	if span["traceId"] != "4bf92f3577b34da6a3ce929d0e0e4736" || span["parentSpanId"] != "00f067aa0ba902b7" {
+		t.Errorf("expected the span to continue the parent trace, got %v", span)
+	}
+	if _, ok := span["seq"]; ok {
+		t.Errorf("expected no seq field on the span")
+	}
+	attrs := attributes(span["attributes"])
+	if attrs["process.command"] != `{"stringValue":"sh"}` ||
+		attrs["process.exit.code"] != `{"intValue":"3"}` ||
+		attrs["deploymentId"] != `{"stringValue":"d1"}` ||
+		!strings.Contains(attrs["process.command_args"], "arrayValue") {
+		t.Errorf("unexpected span attributes %v", attrs)
+	}
+	if status := span["status"].(map[string]any); status["code"] != float64(2) {
+		t.Errorf("expected an error status, got %v", status)
+	}
+
+	wantTraceParent := "00-4bf92f3577b34da6a3ce929d0e0e4736-" + span["spanId"].(string) + "-01"
+	if out != wantTraceParent {
+		t.Errorf("expected the child to get TRACEPARENT %q, got %q", wantTraceParent, out)
+	}
+
+	if len(rcv.logs) < 2 {
+		t.Fatalf("expected log records for stdout and stderr, got %d", len(rcv.logs))
+	}
+	for _, record := range rcv.logs {
+		if record["traceId"] != span["traceId"] || record["spanId"] != span["spanId"] {
+			t.Errorf("expected log records to be correlated with the span, got %v", record)
+		}
+		if attributes(record["attributes"])["deploymentId"] != `{"stringValue":"d1"}` {
+			t.Errorf("expected log KVs as log record attributes, got %v", record["attributes"])
+		}
+	}
+	stderr := rcv.logs[0]
+	if stderr["severityText"] != "ERROR" || stderr["severityNumber"] != float64(17) || stderr["body"].(map[string]any)["stringValue"] != "failing" {
+		t.Errorf("expected stderr as an ERROR record, got %v", stderr)
+	}
+}
+
+func TestTraceParentFromEnvironment(t *testing.T) {
+	ConfigureGlobals()
+	rcv := newOTLPReceiver(t)
+	t.Setenv(TraceParentEnv, "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+
+	captureOutput(func() {
+		New().WithOTLP(OTLPOptions{Endpoint: rcv.URL}).Args("true").Exec()
+	})
+
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+	if len(rcv.spans) != 1 || rcv.spans[0]["traceId"] != "0af7651916cd43dd8448eb211c80319c" || rcv.spans[0]["parentSpanId"] != "b7ad6b7169203331" {
+		t.Errorf("expected the span to continue the trace from TRACEPARENT, got %v", rcv.spans)
+	}
+}
+
+func TestTraceParentPropagatedWithoutExport(t *testing.T) {
+	ConfigureGlobals()
+	parent, _ := ParseTraceParent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	ctx := ContextWithSpanContext(context.Background(), parent)
+
+	var out string
+	captureOutput(func() {
+		out, _ = New().Command("sh").Args("-c", "echo $TRACEPARENT").ExecContext(ctx)
+	})
+	if out != parent.TraceParent() {
+		t.Errorf("expected the parent trace context to be passed on, got %q", out)
+	}
+}
+
+func TestParseTraceParent(t *testing.T) {
+	tests := []struct {
+		value   string
+		wantErr bool
+	}{
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00", false},
+		{"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-future", false},
+		{"00-00000000000000000000000000000000-00f067aa0ba902b7-01", true},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", true},
+		{"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", true},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", true},
+		{"00-xyz-00f067aa0ba902b7-01", true},
+		{"", true},
+	}
+	for _, tt := range tests {
+		sc, err := ParseTraceParent(tt.value)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseTraceParent(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			continue
+		}
+		if err == nil && strings.HasPrefix(tt.value, "00-") && sc.TraceParent() != tt.value {
+			t.Errorf("expected %q to round trip, got %q", tt.value, sc.TraceParent())
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package gosh
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+
+	"github.com/rs/zerolog"
+)
+
+// StageField is the log field holding the index of the pipeline stage that
+// wrote a stderr line. It is only set for pipelines.
+const StageField = "stage"
+
+// StageResult describes one finished stage of a pipeline.
+type StageResult struct {
+	// Command is the command line of the stage with secrets masked.
+	Command string
+	// Path is the resolved path of the executable that was run.
+	Path string
+	// PID is the process ID of the stage, or 0 if it did not start.
+	PID int
+	// ExitCode is the exit code of the stage, or -1 if it did not start
+	// or was terminated by a signal.
+	ExitCode int
+	// Signal is the signal that terminated the stage, or nil.
+	Signal os.Signal
+}
+
+// Pipe connects the standard output of the command to the standard input of
+// next, as cmd | next does in a shell but without one, so arguments are
+// never interpreted. It can be chained to build longer pipelines, and the
+// stages of next, if it is a pipeline itself, are appended as well.
+//
+// Only the command, arguments, directory, environment and secrets of next
+// are used. Everything else, such as logging, timeouts and standard input,
+// is configured on the Shell that Exec or Stream is called on, and applies
+// to the pipeline as a whole. The stages run in one process group, so a
+// timeout or cancellation stops all of them.
+//
+// The exit status of a pipeline is that of its last stage, unless PipeFail
+// is set. Result.Stages reports every stage, and the stderr lines of each
+// stage are logged with its index in the StageField field.
+func (s *Shell) Pipe(next *Shell) *Shell {
+	s.pipe = append(s.pipe, next)
+	s.pipe = append(s.pipe, next.pipe...)
+	return s
+}
+
+// Pipeline connects the given commands into a pipeline, as if the first
+// were piped into each of the others in turn with Pipe, and returns the
+// first. It returns a Shell without a command if none are given.
+func Pipeline(stages ...*Shell) *Shell {
+	if len(stages) == 0 {
+		return New()
+	}
+	for _, next := range stages[1:] {
+		stages[0].Pipe(next)
+	}
+	return stages[0]
+}
+
+// PipeFail gives a pipeline the exit status of its last stage that failed,
+// like set -o pipefail in bash, instead of that of its last stage. A stage
+// killed by SIGPIPE because a later stage stopped reading counts as failed.
+func (s *Shell) PipeFail() *Shell {
+	s.pipefail = true
+	return s
+}
+
+// stages returns the Shell followed by the stages piped from it.
+func (s *Shell) stages() []*Shell {
+	return append([]*Shell{s}, s.pipe...)
+}
+
+// hasCommand reports whether every stage has a command set.
+func (s *Shell) hasCommand() bool {
+	return !slices.ContainsFunc(s.stages(), func(st *Shell) bool { return st.command == "" })
+}
+
+// commandLine returns the command line of the Shell, with its stages
+// separated by pipes.
+func (s *Shell) commandLine() string {
+	stages := s.stages()
+	lines := make([]string, len(stages))
+	for i, st := range stages {
+		lines[i] = formatCommand(append([]string{st.command}, st.args...))
+	}
+	return strings.Join(lines, " | ")
+}
+
+// stderrEvent starts the log event for a stderr line of stage i, which
+// carries the stage index in pipelines.
+func (s *Shell) stderrEvent(i int) *zerolog.Event {
+	e := s.withLogKVs(s.log.Error())
+	if len(s.pipe) > 0 {
+		e = e.Int(StageField, i)
+	}
+	return e
+}
+
+// startPipeline starts cmds with the standard output of each connected to
+// the standard input of the next, in the process group of the first. If a
+// stage fails to start, the stages already started are killed and waited for.
+func startPipeline(cmds []*exec.Cmd) error {
+	// The parent's copies of the pipe ends must be closed once the stages
+	// have inherited them, so readers see EOF and writers SIGPIPE.
+	var pipes []*os.File
+	defer func() {
+		for _, f := range pipes {
+			f.Close()
+		}
+	}()
+	for i := range len(cmds) - 1 {
+		r, w, err := os.Pipe()
+		if err != nil {
+			return err
+		}
+		pipes = append(pipes, r, w)
+		cmds[i].Stdout = w
+		cmds[i+1].Stdin = r
+	}
+
+	for i, cmd := range cmds {
+		if i > 0 {
+			joinProcessGroup(cmd, cmds[0].Process.Pid)
+		}
+		if err := cmd.Start(); err != nil {
+			if i == 0 {
+				return err
+			}
+			killProcess(cmds[0].Process)
+			for _, started := range cmds[:i] {
+				started.Wait()
+			}
+			return fmt.Errorf("stage %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// exitStage returns the index of the stage that decides the exit status of
+// a pipeline: the last one, or with pipefail the last one that failed.
+func (s *Shell) exitStage(cmds []*exec.Cmd, errs []error) int {
+	last := len(cmds) - 1
+	if !s.pipefail {
+		return last
+	}
+	for i := last; i >= 0; i-- {
+		if errs[i] != nil || cmds[i].ProcessState == nil || !cmds[i].ProcessState.Success() {
+			return i
+		}
+	}
+	return last
+}
+
+// stageResults describes the finished stages of a pipeline.
+func (s *Shell) stageResults(cmds []*exec.Cmd) []StageResult {
+	stages := s.stages()
+	results := make([]StageResult, len(cmds))
+	for i, cmd := range cmds {
+		r := StageResult{
+			Command:  s.mask(formatCommand(append([]string{stages[i].command}, stages[i].args...))),
+			Path:     cmd.Path,
+			ExitCode: -1,
+		}
+		if cmd.Process != nil {
+			r.PID = cmd.Process.Pid
+		}
+		if cmd.ProcessState != nil {
+			r.ExitCode = cmd.ProcessState.ExitCode()
+			r.Signal = exitSignal(cmd.ProcessState)
+		}
+		results[i] = r
+	}
+	return results
+}
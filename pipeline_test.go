@@ -0,0 +1,223 @@
+package gosh
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestPipe(t *testing.T) {
+	var res *Result
+	var err error
+	captureOutput(func() {
+		res, err = New().
+			Command("printf").Args("b\\na\\nc\\n").
+			Pipe(New().Command("sort")).
+			Pipe(New().Command("head").Args("-n", "2")).
+			ExecResult()
+	})
+	if err != nil {
+		t.Fatalf("expected pipeline to succeed, but it failed: %v", err)
+	}
+	if got := string(res.Stdout); got != "a\nb\n" {
+		t.Errorf("expected %q, got %q", "a\nb\n", got)
+	}
+	if len(res.Stages) != 3 {
+		t.Fatalf("expected 3 stages, got %+v", res.Stages)
+	}
+	for i, stage := range res.Stages {
+		if stage.ExitCode != 0 || stage.PID == 0 {
+			t.Errorf("unexpected stage %d: %+v", i, stage)
+		}
+	}
+	if res.Stages[2].Command != "head -n 2" || res.PID != res.Stages[0].PID {
+		t.Errorf("unexpected pipeline result: %+v", res)
+	}
+}
+
+func TestPipelineArgsNotInterpreted(t *testing.T) {
+	var out string
+	var err error
+	captureOutput(func() {
+		out, err = Pipeline(
+			New().Command("echo").Args("a | b; $HOME"),
+			New().Command("cat"),
+			New().Command("cat"),
+		).Exec()
+	})
+	if err != nil || out != "a | b; $HOME" {
+		t.Errorf("expected the argument verbatim, got %q, %v", out, err)
+	}
+}
+
+func TestPipelineLargeOutput(t *testing.T) {
+	var out string
+	var err error
+	captureOutput(func() {
+		out, err = New().Command("seq").Args("1", "200000").
+			Pipe(New().Command("wc").Args("-l")).
+			Exec()
+	})
+	if err != nil || strings.TrimSpace(out) != "200000" {
+		t.Errorf("expected 200000 lines, got %q, %v", out, err)
+	}
+}
+
+func TestPipelineStdin(t *testing.T) {
+	var out string
+	var err error
+	captureOutput(func() {
+		out, err = New().StdinString("xox").Command("cat").
+			Pipe(New().Command("tr").Args("x", "y")).
+			Exec()
+	})
+	if err != nil || out != "yoy" {
+		t.Errorf("expected %q, got %q, %v", "yoy", out, err)
+	}
+}
+
+func TestPipeFail(t *testing.T) {
+	tests := []struct {
+		name     string
+		pipefail bool
+		wantCode int
+	}{
+		{"last stage decides", false, 0},
+		{"pipefail", true, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var res *Result
+			var err error
+			captureOutput(func() {
+				s := New().Command("sh").Args("-c", "echo out; exit 3").
+					Pipe(New().Command("sh").Args("-c", "cat; exit 0"))
+				if tt.pipefail {
+					s.PipeFail()
+				}
+				res, err = s.ExecResult()
+			})
+			if res.ExitCode != tt.wantCode || (err != nil) != (tt.wantCode != 0) {
+				t.Fatalf("expected exit code %d, got %d, %v", tt.wantCode, res.ExitCode, err)
+			}
+			if res.Stages[0].ExitCode != 3 || res.Stages[1].ExitCode != 0 {
+				t.Errorf("unexpected stage exit codes: %+v", res.Stages)
+			}
+			var exitErr *ExitError
+			if tt.pipefail && (!errors.As(err, &exitErr) || exitErr.Command != "sh -c 'echo out; exit 3' | sh -c 'cat; exit 0'") {
+				t.Errorf("expected an ExitError for the pipeline, got %v", err)
+			}
+		})
+	}
+}
+
+func TestPipeFailSIGPIPE(t *testing.T) {
+	var res *Result
+	var err error
+	captureOutput(func() {
+		res, err = New().Command("yes").
+			Pipe(New().Command("head").Args("-n", "1")).
+			PipeFail().
+			ExecResult()
+	})
+	if err == nil || res.Stages[0].Signal != syscall.SIGPIPE || string(res.Stdout) != "y\n" {
+		t.Errorf("expected the first stage to fail with SIGPIPE, got %+v, %v", res, err)
+	}
+}
+
+func TestPipelineStderrStage(t *testing.T) {
+	ConfigureGlobals()
+
+	logOutput := captureOutput(func() {
+		New().WithLifecycleEvents().
+			Command("sh").Args("-c", "echo first >&2; echo data").
+			Pipe(New().Command("sh").Args("-c", "cat >/dev/null; echo second >&2; exit 2")).
+			Stream()
+	})
+
+	stages := make(map[string]any)
+	var finished map[string]any
+	for _, entry := range parseLogLines(t, logOutput) {
+		if entry["level"] == "error" && entry["event"] == nil {
+			stages[entry["msg"].(string)] = entry[StageField]
+		}
+		if entry["event"] == EventCommandFinished {
+			finished = entry
+		}
+	}
+	if stages["first"] != float64(0) || stages["second"] != float64(1) {
+		t.Errorf("expected stderr lines with stage indexes, got %v", stages)
+	}
+	codes, _ := finished["stage_exit_codes"].([]any)
+	if !slices.Equal(codes, []any{float64(0), float64(2)}) || finished["exit_code"] != float64(2) {
+		t.Errorf("expected stage exit codes in finished event, got %v", finished)
+	}
+
+	// Single commands keep their stderr lines unchanged
+	logOutput = captureOutput(func() {
+		New().Command("sh").Args("-c", "echo oops >&2").Stream()
+	})
+	if entries := parseLogLines(t, logOutput); entries[0][StageField] != nil {
+		t.Errorf("expected no stage field for a single command, got %v", entries[0])
+	}
+}
+
+func TestPipelineTimeout(t *testing.T) {
+	start := time.Now()
+	var res *Result
+	var err error
+	captureOutput(func() {
+		res, err = New().Timeout(100 * time.Millisecond).GracePeriod(0).
+			Command("sleep").Args("10").
+			Pipe(New().Command("sleep").Args("10")).
+			ExecResult()
+	})
+	if !errors.Is(err, ErrTimeout) {
+		t.Fatalf("expected a timeout, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("expected every stage to be killed, took %s", elapsed)
+	}
+	for i, stage := range res.Stages {
+		if stage.Signal != syscall.SIGKILL {
+			t.Errorf("expected stage %d to be killed, got %+v", i, stage)
+		}
+	}
+}
+
+func TestPipelineFailsDespiteOrphans(t *testing.T) {
+	var err error
+	logOutput := captureOutput(func() {
+		_, err = New().Command("sh").Args("-c", "sleep 30 &").
+			Pipe(New().Command("sh").Args("-c", "exit 3")).
+			WaitDelay(100 * time.Millisecond).
+			Exec()
+	})
+	var exitErr *ExitError
+	if !errors.As(err, &exitErr) || exitErr.ExitCode != 3 {
+		t.Errorf("expected the last stage to fail with exit code 3, got %v", err)
+	}
+	if !strings.Contains(logOutput, `"event":"orphans_killed"`) {
+		t.Errorf("expected an orphans_killed event, got %q", logOutput)
+	}
+}
+
+func TestPipelineStageNotFound(t *testing.T) {
+	var err error
+	captureOutput(func() {
+		_, err = New().Command("sleep").Args("10").
+			Pipe(New().Command("gosh-no-such-command")).
+			Exec()
+	})
+	if !errors.Is(err, ErrNotFound) || !strings.Contains(err.Error(), "stage 1") {
+		t.Errorf("expected a not found error for stage 1, got %v", err)
+	}
+
+	_, err = New().Command("echo").Pipe(New()).Exec()
+	if err != errNoCommand {
+		t.Errorf("expected errNoCommand for an empty stage, got %v", err)
+	}
+}
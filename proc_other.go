@@ -0,0 +1,37 @@
+//go:build !unix
+
+package gosh
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+)
+
+// setProcessGroup is a no-op on platforms without Unix process groups.
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// joinProcessGroup is a no-op on platforms without Unix process groups.
+func joinProcessGroup(cmd *exec.Cmd, pgid int) {}
+
+// terminateProcess reports that graceful termination is not supported on
+// this platform, so callers fall back to killing the process.
+func terminateProcess(p *os.Process) error {
+	return errors.ErrUnsupported
+}
+
+// killProcess kills p. Its descendants are not tracked on this platform.
+func killProcess(p *os.Process) error {
+	return p.Kill()
+}
+
+// exitSignal returns nil since processes are not terminated by signals on
+// this platform.
+func exitSignal(ps *os.ProcessState) os.Signal {
+	return nil
+}
+
+// maxRSSKB reports that resource usage is not available on this platform.
+func maxRSSKB(ps *os.ProcessState) (int64, bool) {
+	return 0, false
+}
@@ -0,0 +1,68 @@
+//go:build unix
+
+package gosh
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"runtime"
+	"syscall"
+)
+
+// setProcessGroup makes cmd the leader of a new process group, so signals
+// can be delivered to every process it spawns.
+func setProcessGroup(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setpgid = true
+}
+
+// joinProcessGroup makes cmd join the process group pgid when it starts.
+func joinProcessGroup(cmd *exec.Cmd, pgid int) {
+	setProcessGroup(cmd)
+	cmd.SysProcAttr.Pgid = pgid
+}
+
+// terminateProcess asks the process group led by p to exit by sending it SIGTERM.
+func terminateProcess(p *os.Process) error {
+	return signalGroup(p, syscall.SIGTERM)
+}
+
+// killProcess kills every process in the group led by p.
+func killProcess(p *os.Process) error {
+	return signalGroup(p, syscall.SIGKILL)
+}
+
+// signalGroup sends sig to the process group led by p. The group ID equals
+// the leader's PID because commands are started with Setpgid, and the kernel
+// does not reuse it while any member of the group is alive.
+func signalGroup(p *os.Process, sig syscall.Signal) error {
+	err := syscall.Kill(-p.Pid, sig)
+	if errors.Is(err, syscall.ESRCH) {
+		return os.ErrProcessDone
+	}
+	return err
+}
+
+// exitSignal returns the signal that terminated the process, or nil.
+func exitSignal(ps *os.ProcessState) os.Signal {
+	if status, ok := ps.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		return status.Signal()
+	}
+	return nil
+}
+
+// maxRSSKB returns the peak resident set size of the process in kilobytes.
+func maxRSSKB(ps *os.ProcessState) (int64, bool) {
+	usage, ok := ps.SysUsage().(*syscall.Rusage)
+	if !ok {
+		return 0, false
+	}
+	// Darwin reports ru_maxrss in bytes, other systems in kilobytes
+	if runtime.GOOS == "darwin" || runtime.GOOS == "ios" {
+		return int64(usage.Maxrss) / 1024, true
+	}
+	return int64(usage.Maxrss), true
+}
@@ -0,0 +1,144 @@
+//go:build unix
+
+package gosh
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// processGone reports whether pid has exited. A zombie counts as exited.
+func processGone(pid int) bool {
+	if err := syscall.Kill(pid, 0); errors.Is(err, syscall.ESRCH) {
+		return true
+	}
+	stat, err := os.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "stat"))
+	if err != nil {
+		return false
+	}
+	// The state follows the parenthesised command name: "pid (comm) S ..."
+	fields := strings.Fields(string(stat[strings.LastIndexByte(string(stat), ')')+1:]))
+	return len(fields) > 0 && fields[0] == "Z"
+}
+
+// readPID waits for a command to write its background PID to path.
+func readPID(t *testing.T, path string) int {
+	t.Helper()
+	for i := 0; i < 50; i++ {
+		data, err := os.ReadFile(path)
+		if err == nil && strings.HasSuffix(string(data), "\n") {
+			pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+			if err != nil {
+				t.Fatalf("invalid pid file: %v", err)
+			}
+			return pid
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatalf("pid file %s was not written", path)
+	return 0
+}
+
+func TestStreamDoesNotHangOnBackgroundDescendants(t *testing.T) {
+	ConfigureGlobals()
+
+	var err error
+	start := time.Now()
+	logOutput := captureOutput(func() {
+		err = New().
+			Command("sh").
+			Args("-c", "sleep 30 & echo started").
+			WaitDelay(100 * time.Millisecond).
+			Stream()
+	})
+
+	if err != nil {
+		t.Fatalf("expected command to succeed, but it failed: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("expected Stream to return after the wait delay, took %v", elapsed)
+	}
+	if !strings.Contains(logOutput, "started") || !strings.Contains(logOutput, `"event":"orphans_killed"`) {
+		t.Errorf("expected output and orphans_killed event, got %q", logOutput)
+	}
+}
+
+func TestBlockedStdinIsNotOrphans(t *testing.T) {
+	ConfigureGlobals()
+
+	unblock := make(chan struct{})
+	defer close(unblock)
+	var err error
+	logOutput := captureOutput(func() {
+		err = New().
+			Stdin(blockingReader{unblock}).
+			Command("echo").
+			Args("hi").
+			WaitDelay(100 * time.Millisecond).
+			Stream()
+	})
+
+	if err != nil {
+		t.Fatalf("expected command to succeed, but it failed: %v", err)
+	}
+	if strings.Contains(logOutput, "orphans_killed") {
+		t.Errorf("expected no orphans_killed event for a blocked stdin, got %q", logOutput)
+	}
+
+	stdinCopier := exec.Command("cat")
+	stdinCopier.Stdin = strings.NewReader("")
+	if heldOutput(stdinCopier, exec.ErrWaitDelay) {
+		t.Error("expected a wait delay on a copied stdin not to count as held output")
+	}
+}
+
+func TestTerminateExitedGroup(t *testing.T) {
+	cmd := exec.Command("true")
+	setProcessGroup(cmd)
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, grace := range []time.Duration{0, time.Minute} {
+		s := New().GracePeriod(grace)
+		if got := s.terminate(cmd.Process, make(chan struct{})); got != OutcomeTerminated {
+			t.Errorf("grace period %v: expected outcome %q for a group that is gone, got %q", grace, OutcomeTerminated, got)
+		}
+	}
+}
+
+func TestTimeoutKillsProcessGroup(t *testing.T) {
+	ConfigureGlobals()
+
+	pidFile := filepath.Join(t.TempDir(), "pid")
+
+	var err error
+	captureOutput(func() {
+		err = New().
+			Command("sh").
+			Args("-c", "sleep 30 & echo $! > "+pidFile+"; wait").
+			Timeout(200 * time.Millisecond).
+			Stream()
+	})
+
+	if !errors.Is(err, ErrTimeout) {
+		t.Fatalf("expected error to match ErrTimeout, got %v", err)
+	}
+
+	pid := readPID(t, pidFile)
+	deadline := time.Now().Add(2 * time.Second)
+	for !processGone(pid) {
+		if time.Now().After(deadline) {
+			syscall.Kill(pid, syscall.SIGKILL)
+			t.Fatalf("expected grandchild %d to be killed with the process group", pid)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
@@ -0,0 +1,88 @@
+package gosh
+
+const (
+	// DefaultMaxQueueBytes bounds the memory an HTTPStreamWriter uses for
+	// lines waiting to be sent, unless changed with WithQueueLimit.
+	DefaultMaxQueueBytes = 8 << 20
+	// DefaultSampleEvery is the fraction of lines kept under OverflowSample
+	// while the queue is full, unless changed with WithSampleEvery.
+	DefaultSampleEvery = 10
+)
+
+// OverflowPolicy selects what an HTTPStreamWriter does with a line that
+// arrives while its queue is full.
+type OverflowPolicy int
+
+const (
+	// OverflowBlock makes Write wait until the sender has made room, slowing
+	// the command down to the pace of the endpoint. No lines are lost.
+	OverflowBlock OverflowPolicy = iota
+	// OverflowDropOldest discards the oldest queued lines to make room.
+	OverflowDropOldest
+	// OverflowDropNewest discards the line that does not fit.
+	OverflowDropNewest
+	// OverflowSample keeps one in every N lines that arrive while the queue
+	// is full, discarding the oldest queued lines to make room for it.
+	OverflowSample
+)
+
+// WithQueueLimit bounds the bytes of lines waiting to be sent and sets what
+// happens to lines that arrive while the queue is full.
+func WithQueueLimit(maxBytes int, policy OverflowPolicy) HTTPStreamOption {
+	return func(w *HTTPStreamWriter) {
+		w.maxQueueBytes = maxBytes
+		w.overflow = policy
+	}
+}
+
+// WithSampleEvery sets N for OverflowSample: one in every n lines arriving
+// while the queue is full is kept.
+func WithSampleEvery(n int) HTTPStreamOption {
+	return func(w *HTTPStreamWriter) {
+		w.sampleEvery = max(n, 1)
+	}
+}
+
+// admit makes room in the queue for a record of size bytes according to the
+// overflow policy, and reports whether the record should be queued.
+// A record larger than the whole queue is admitted once the queue is empty.
+// The caller must hold the mutex.
+func (w *HTTPStreamWriter) admit(size int) bool {
+	full := func() bool {
+		return len(w.queue) > 0 && w.queued+size > w.maxQueueBytes
+	}
+	if !full() {
+		return true
+	}
+
+	switch w.overflow {
+	case OverflowDropNewest:
+		return false
+	case OverflowSample:
+		w.overflows++
+		if w.overflows%w.sampleEvery != 0 {
+			return false
+		}
+		w.dropOldest(full)
+	case OverflowDropOldest:
+		w.dropOldest(full)
+	default:
+		w.blocked++
+		for full() && w.running {
+			w.kickSender()
+			w.spaceFreed.Wait()
+		}
+		w.blocked--
+	}
+	return true
+}
+
+// dropOldest discards queued records from the front while full reports true.
+// The caller must hold the mutex.
+func (w *HTTPStreamWriter) dropOldest(full func() bool) {
+	for full() {
+		w.queued -= len(w.queue[0])
+		w.queue = w.queue[1:]
+		w.dropped.Add(1)
+	}
+}
@@ -0,0 +1,163 @@
+package gosh
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// gatedServer holds every request until release is closed.
+type gatedServer struct {
+	*httptest.Server
+	release chan struct{}
+
+	mu       sync.Mutex
+	received []string
+}
+
+func newGatedServer(t *testing.T) *gatedServer {
+	t.Helper()
+	gs := &gatedServer{release: make(chan struct{})}
+	gs.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-gs.release
+		body, _ := io.ReadAll(r.Body)
+		gs.mu.Lock()
+		gs.received = append(gs.received, strings.Split(strings.TrimSuffix(string(body), "\n"), "\n")...)
+		gs.mu.Unlock()
+	}))
+	t.Cleanup(gs.Close)
+	return gs
+}
+
+// waitForInFlight waits until the writer has a request in progress.
+func waitForInFlight(t *testing.T, w *HTTPStreamWriter) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for w.Stats().InFlight == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("expected a request to be in flight")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestHTTPStreamWriterDropNewest(t *testing.T) {
+	srv := newGatedServer(t)
+
+	w := NewHTTPStreamWriter(srv.URL, nil, WithMaxBatchBytes(32), WithQueueLimit(256, OverflowDropNewest))
+	io.WriteString(w, "{\"msg\":\"first\"}\n")
+	waitForInFlight(t, w)
+
+	for i := 0; i < 100; i++ {
+		fmt.Fprintf(w, "{\"msg\":\"line %d\"}\n", i)
+	}
+
+	stats := w.Stats()
+	if stats.QueuedBytes > 256 {
+		t.Errorf("expected queue to stay within its limit, got %d bytes", stats.QueuedBytes)
+	}
+	if stats.Dropped == 0 {
+		t.Error("expected lines to be dropped while the endpoint is slow")
+	}
+	if stats.InFlight != 1 {
+		t.Errorf("expected one request in flight, got %d", stats.InFlight)
+	}
+
+	close(srv.release)
+	w.Close()
+
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if uint64(len(srv.received)) != 101-stats.Dropped {
+		t.Errorf("expected %d delivered lines, got %d", 101-stats.Dropped, len(srv.received))
+	}
+	if !strings.Contains(srv.received[1], "\"line 0\"") {
+		t.Errorf("expected the oldest lines to be kept, got %s", srv.received[1])
+	}
+}
+
+func TestHTTPStreamWriterDropOldest(t *testing.T) {
+	srv := newGatedServer(t)
+
+	w := NewHTTPStreamWriter(srv.URL, nil, WithMaxBatchBytes(32), WithQueueLimit(256, OverflowDropOldest))
+	io.WriteString(w, "{\"msg\":\"first\"}\n")
+	waitForInFlight(t, w)
+
+	for i := 0; i < 100; i++ {
+		fmt.Fprintf(w, "{\"msg\":\"line %d\"}\n", i)
+	}
+	close(srv.release)
+	w.Close()
+
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	last := srv.received[len(srv.received)-1]
+	if !strings.Contains(last, "\"line 99\"") {
+		t.Errorf("expected the newest line to be kept, got %s", last)
+	}
+	if w.Stats().Dropped == 0 {
+		t.Error("expected the oldest lines to be dropped")
+	}
+}
+
+func TestHTTPStreamWriterBlock(t *testing.T) {
+	srv := newGatedServer(t)
+
+	w := NewHTTPStreamWriter(srv.URL, nil, WithMaxBatchBytes(32), WithQueueLimit(128, OverflowBlock))
+	io.WriteString(w, "{\"msg\":\"first\"}\n")
+	waitForInFlight(t, w)
+
+	written := make(chan struct{})
+	go func() {
+		for i := 0; i < 50; i++ {
+			fmt.Fprintf(w, "{\"msg\":\"line %d\"}\n", i)
+		}
+		close(written)
+	}()
+
+	select {
+	case <-written:
+		t.Fatal("expected writes to block while the queue is full")
+	case <-time.After(100 * time.Millisecond):
+	}
+	if queued := w.Stats().QueuedBytes; queued > 128 {
+		t.Errorf("expected queue to stay within its limit, got %d bytes", queued)
+	}
+
+	close(srv.release)
+	<-written
+	w.Close()
+
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if len(srv.received) != 51 || w.Stats().Dropped != 0 {
+		t.Errorf("expected every line to be delivered, got %d lines and %d dropped", len(srv.received), w.Stats().Dropped)
+	}
+}
+
+func TestOverflowSample(t *testing.T) {
+	w := NewHTTPStreamWriter("http://unused.invalid", nil,
+		WithQueueLimit(10, OverflowSample),
+		WithSampleEvery(4))
+	w.queue = [][]byte{make([]byte, 10)}
+	w.queued = 10
+
+	kept := 0
+	for i := 0; i < 8; i++ {
+		w.mutex.Lock()
+		if w.admit(10) {
+			kept++
+			w.queue = [][]byte{make([]byte, 10)}
+			w.queued = 10
+		}
+		w.mutex.Unlock()
+	}
+	if kept != 2 {
+		t.Errorf("expected 2 of 8 overflowing lines to be sampled, got %d", kept)
+	}
+}
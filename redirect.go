@@ -0,0 +1,169 @@
+package gosh
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+)
+
+// StdoutTo copies the raw standard output of the command to w as it is
+// produced, byte for byte, in addition to logging or capturing it. It can
+// be called several times to copy to several writers.
+func (s *Shell) StdoutTo(w io.Writer) *Shell {
+	s.redirects = append(s.redirects, outputTarget{w: w, stdout: true})
+	return s
+}
+
+// StderrTo copies the raw standard error of the command to w as it is
+// produced, like StdoutTo. For a pipeline, the stderr of every stage is copied.
+func (s *Shell) StderrTo(w io.Writer) *Shell {
+	s.redirects = append(s.redirects, outputTarget{w: w, stderr: true})
+	return s
+}
+
+// TeeTo copies the raw standard output and standard error of the command to
+// the file at path, truncating it first. The file is opened when the command
+// starts; if it cannot be opened the command is not started. Use MergeStderr
+// for the two streams to appear in the file in exactly the order they were written.
+func (s *Shell) TeeTo(path string) *Shell {
+	s.redirects = append(s.redirects, outputTarget{path: path, stdout: true, stderr: true})
+	return s
+}
+
+// AppendTo is like TeeTo but appends to the file instead of truncating it.
+func (s *Shell) AppendTo(path string) *Shell {
+	s.redirects = append(s.redirects, outputTarget{path: path, append: true, stdout: true, stderr: true})
+	return s
+}
+
+// MergeStderr sends the standard error of the command to the same pipe as
+// its standard output, like 2>&1 in a shell, so the two are interleaved in
+// exactly the order they were written. The merged output is logged,
+// captured and copied as stdout, and Result.Stderr stays empty. For a
+// pipeline, only the stderr of the last stage is merged.
+func (s *Shell) MergeStderr() *Shell {
+	s.mergeStderr = true
+	return s
+}
+
+// outputTarget is a writer or file the raw output of a command is copied to.
+type outputTarget struct {
+	w      io.Writer
+	path   string // file to open when w is nil
+	append bool
+	stdout bool
+	stderr bool
+}
+
+// outputCopies copies the output of a run to its targets. Writes are
+// serialized because stdout and stderr arrive concurrently and may share
+// a target. A target that fails to accept a write gets no further output,
+// without affecting the other targets, and its error is returned by run
+// once the command has finished, unless the command itself failed.
+type outputCopies struct {
+	mutex   sync.Mutex
+	targets []io.Writer
+	errs    []error // first write error of each target
+	files   []*os.File
+}
+
+// redirectOutput opens the files of the configured redirects and wraps the
+// stdout of the last of cmds and the stderr of each so that their output is
+// also copied to the targets. It returns nil if there is nothing to copy.
+func (s *Shell) redirectOutput(cmds []*exec.Cmd) (*outputCopies, error) {
+	last := cmds[len(cmds)-1]
+	defer func() {
+		if s.mergeStderr {
+			last.Stderr = last.Stdout
+		}
+	}()
+	if len(s.redirects) == 0 {
+		return nil, nil
+	}
+
+	c := &outputCopies{errs: make([]error, len(s.redirects))}
+	var stdout, stderr []int // indexes of the targets of each stream
+	for i, r := range s.redirects {
+		w := r.w
+		if w == nil {
+			flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+			if r.append {
+				flags = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+			}
+			f, err := os.OpenFile(r.path, flags, 0o644)
+			if err != nil {
+				c.close()
+				return nil, fmt.Errorf("opening output file: %w", err)
+			}
+			c.files = append(c.files, f)
+			w = f
+		}
+		c.targets = append(c.targets, w)
+		if r.stdout {
+			stdout = append(stdout, i)
+		}
+		if r.stderr {
+			stderr = append(stderr, i)
+		}
+	}
+
+	last.Stdout = &copyWriter{w: last.Stdout, copies: c, targets: stdout}
+	if !s.mergeStderr {
+		// Merged stderr is copied as stdout
+		last.Stderr = &copyWriter{w: last.Stderr, copies: c, targets: stderr}
+	}
+	for _, cmd := range cmds[:len(cmds)-1] {
+		cmd.Stderr = &copyWriter{w: cmd.Stderr, copies: c, targets: stderr}
+	}
+	return c, nil
+}
+
+// write copies p to the targets with the given indexes.
+func (c *outputCopies) write(targets []int, p []byte) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for _, i := range targets {
+		if c.errs[i] != nil {
+			continue
+		}
+		if _, err := c.targets[i].Write(p); err != nil {
+			c.errs[i] = err
+		}
+	}
+}
+
+// close closes the files opened for the targets and returns the write and
+// close errors, joined, or nil. It is safe to call on a nil *outputCopies.
+func (c *outputCopies) close() error {
+	if c == nil {
+		return nil
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	errs := c.errs
+	for _, f := range c.files {
+		errs = append(errs, f.Close())
+	}
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("copying output: %w", err)
+	}
+	return nil
+}
+
+// copyWriter writes to w, where the output is logged or captured, and
+// copies what w accepted to targets.
+type copyWriter struct {
+	w       io.Writer
+	copies  *outputCopies
+	targets []int
+}
+
+// Write implements io.Writer interface
+func (w *copyWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.copies.write(w.targets, p[:n])
+	return n, err
+}
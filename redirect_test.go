@@ -0,0 +1,155 @@
+package gosh
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestStdoutStderrTo(t *testing.T) {
+	const script = `printf '  out 1 \r\n\nout 2'; printf ' err\n' >&2`
+	for _, mode := range []string{"exec", "stream"} {
+		t.Run(mode, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			var err error
+			captureOutput(func() {
+				s := New().StdoutTo(&stdout).StderrTo(&stderr).Command("sh").Args("-c", script)
+				if mode == "exec" {
+					_, err = s.Exec()
+				} else {
+					err = s.Stream()
+				}
+			})
+			if err != nil {
+				t.Fatalf("expected command to succeed, but it failed: %v", err)
+			}
+			if got := stdout.String(); got != "  out 1 \r\n\nout 2" {
+				t.Errorf("expected raw stdout, got %q", got)
+			}
+			if got := stderr.String(); got != " err\n" {
+				t.Errorf("expected raw stderr, got %q", got)
+			}
+		})
+	}
+}
+
+func TestTeeToAndAppendTo(t *testing.T) {
+	dir := t.TempDir()
+	tee := filepath.Join(dir, "tee.log")
+	appended := filepath.Join(dir, "append.log")
+	if err := os.WriteFile(tee, []byte("old\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(appended, []byte("old\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var err error
+	captureOutput(func() {
+		s := New().TeeTo(tee).AppendTo(appended).MergeStderr().
+			Command("sh").Args("-c", "echo one; echo two >&2; echo three")
+		for i := 0; i < 2 && err == nil; i++ {
+			_, err = s.Exec()
+		}
+	})
+	if err != nil {
+		t.Fatalf("expected command to succeed, but it failed: %v", err)
+	}
+
+	if got, _ := os.ReadFile(tee); string(got) != "one\ntwo\nthree\n" {
+		t.Errorf("expected the tee file to hold the last run, got %q", got)
+	}
+	if got, _ := os.ReadFile(appended); string(got) != "old\none\ntwo\nthree\none\ntwo\nthree\n" {
+		t.Errorf("expected both runs appended, got %q", got)
+	}
+}
+
+func TestMergeStderr(t *testing.T) {
+	ConfigureGlobals()
+
+	var res *Result
+	var err error
+	captureOutput(func() {
+		res, err = New().MergeStderr().
+			Command("sh").Args("-c", "echo 1; echo 2 >&2; echo 3; echo 4 >&2").
+			ExecResult()
+	})
+	if err != nil {
+		t.Fatalf("expected command to succeed, but it failed: %v", err)
+	}
+	if string(res.Stdout) != "1\n2\n3\n4\n" || len(res.Stderr) != 0 {
+		t.Errorf("expected merged output in order, got stdout %q, stderr %q", res.Stdout, res.Stderr)
+	}
+
+	logOutput := captureOutput(func() {
+		New().MergeStderr().Command("sh").Args("-c", "echo 1; echo 2 >&2; echo 3").Stream()
+	})
+	var msgs []string
+	for _, entry := range parseLogLines(t, logOutput) {
+		if entry["level"] != "info" {
+			t.Errorf("expected merged output to be logged as stdout, got %v", entry)
+		}
+		msgs = append(msgs, entry["msg"].(string))
+	}
+	if strings.Join(msgs, ",") != "1,2,3" {
+		t.Errorf("expected lines in order, got %q", msgs)
+	}
+}
+
+func TestRedirectPipeline(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	var err error
+	captureOutput(func() {
+		_, err = New().StdoutTo(&stdout).StderrTo(&stderr).
+			Command("sh").Args("-c", "echo data; echo first >&2").
+			Pipe(New().Command("sh").Args("-c", "cat; sleep 0.1; echo second >&2")).
+			Exec()
+	})
+	if err != nil {
+		t.Fatalf("expected pipeline to succeed, but it failed: %v", err)
+	}
+	if stdout.String() != "data\n" || stderr.String() != "first\nsecond\n" {
+		t.Errorf("unexpected copies: stdout %q, stderr %q", stdout.String(), stderr.String())
+	}
+}
+
+type errWriter struct{}
+
+func (errWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("disk full")
+}
+
+func TestRedirectWriteError(t *testing.T) {
+	var copied bytes.Buffer
+	var res *Result
+	var err error
+	captureOutput(func() {
+		res, err = New().StdoutTo(errWriter{}).StdoutTo(&copied).
+			Command("echo").Args("hello").
+			ExecResult()
+	})
+	var exitErr *ExitError
+	if !errors.As(err, &exitErr) || exitErr.ExitCode != 0 || !strings.Contains(err.Error(), "disk full") {
+		t.Errorf("expected the write error for a successful command, got %v", err)
+	}
+	if string(res.Stdout) != "hello\n" || copied.String() != "hello\n" {
+		t.Errorf("expected output to reach the other writers, got %q and %q", res.Stdout, copied.String())
+	}
+}
+
+func TestTeeToOpenError(t *testing.T) {
+	var res *Result
+	var err error
+	captureOutput(func() {
+		res, err = New().TeeTo(filepath.Join(t.TempDir(), "missing", "out.log")).
+			Command("echo").
+			ExecResult()
+	})
+	if !errors.Is(err, fs.ErrNotExist) || res.PID != 0 {
+		t.Errorf("expected the command not to start, got %+v, %v", res, err)
+	}
+}
@@ -0,0 +1,50 @@
+package gosh
+
+import (
+	"os"
+	"time"
+)
+
+// Result describes a finished command.
+type Result struct {
+	// Stdout and Stderr hold the raw, untrimmed output of the command.
+	// They are nil for results returned by StreamResult.
+	Stdout []byte
+	Stderr []byte
+
+	// ExitCode is the exit code of the command, or -1 if it did not start
+	// or was terminated by a signal.
+	ExitCode int
+	// Signal is the signal that terminated the command, or nil.
+	Signal os.Signal
+
+	StartTime time.Time
+	EndTime   time.Time
+	Duration  time.Duration
+
+	// PID is the process ID of the command, or 0 if it did not start.
+	// For a pipeline it is that of the first stage, which leads the
+	// process group of the pipeline.
+	PID int
+	// Path is the resolved path of the executable that was run, the first
+	// stage's for a pipeline.
+	Path string
+	// TimedOut reports whether the command was stopped because it exceeded its Timeout.
+	TimedOut bool
+	// StdinBytes is the number of bytes read from the source of standard
+	// input. It may exceed what the command consumed, as input is buffered
+	// in a pipe, and is 0 for an *os.File that cannot seek.
+	StdinBytes int64
+	// Stages describes every stage of a pipeline built with Pipe, in order.
+	// It is nil for a single command. ExitCode and Signal are those of the
+	// stage that decides the exit status of the pipeline.
+	Stages []StageResult
+	// Matches holds the lines that matched OnMatch, AbortOn and Expect
+	// patterns, in the order they were produced.
+	Matches []Match
+}
+
+// Success reports whether the command exited with status 0.
+func (r *Result) Success() bool {
+	return r.ExitCode == 0
+}
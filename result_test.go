@@ -0,0 +1,98 @@
+package gosh
+
+import (
+	"path/filepath"
+	"runtime"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestExecResult(t *testing.T) {
+	ConfigureGlobals()
+
+	var res *Result
+	var err error
+	captureOutput(func() {
+		res, err = New().
+			Command("sh").
+			Args("-c", "printf ' out \\n'; printf 'oops' >&2; exit 3").
+			ExecResult()
+	})
+
+	if err == nil {
+		t.Fatal("expected command to fail, but it succeeded")
+	}
+	if res == nil {
+		t.Fatal("expected a result for a failed command")
+	}
+	if string(res.Stdout) != " out \n" {
+		t.Errorf("expected raw stdout %q, got %q", " out \n", res.Stdout)
+	}
+	if string(res.Stderr) != "oops" {
+		t.Errorf("expected raw stderr %q, got %q", "oops", res.Stderr)
+	}
+	if res.ExitCode != 3 || res.Success() {
+		t.Errorf("expected exit code 3, got %d", res.ExitCode)
+	}
+	if res.Signal != nil {
+		t.Errorf("expected no signal, got %v", res.Signal)
+	}
+	if res.PID == 0 {
+		t.Error("expected PID to be set")
+	}
+	if !filepath.IsAbs(res.Path) {
+		t.Errorf("expected resolved absolute path, got %q", res.Path)
+	}
+	if res.Duration <= 0 || !res.EndTime.After(res.StartTime) {
+		t.Errorf("expected positive duration, got %v", res.Duration)
+	}
+}
+
+func TestExecResultSignal(t *testing.T) {
+	ConfigureGlobals()
+
+	if runtime.GOOS == "windows" {
+		t.Skip("Skipping signal test on Windows.")
+	}
+
+	var res *Result
+	captureOutput(func() {
+		res, _ = New().Command("sh").Args("-c", "kill -9 $$").ExecResult()
+	})
+
+	if res.Signal != syscall.SIGKILL {
+		t.Errorf("expected signal %v, got %v", syscall.SIGKILL, res.Signal)
+	}
+	if res.ExitCode != -1 {
+		t.Errorf("expected exit code -1 for a signalled command, got %d", res.ExitCode)
+	}
+}
+
+func TestStreamResultTimedOut(t *testing.T) {
+	ConfigureGlobals()
+
+	var res *Result
+	captureOutput(func() {
+		res, _ = New().Args("sleep", "10").Timeout(50 * time.Millisecond).StreamResult()
+	})
+
+	if !res.TimedOut {
+		t.Error("expected result to report the timeout")
+	}
+	if res.Stdout != nil || res.Stderr != nil {
+		t.Error("expected streamed output not to be captured")
+	}
+}
+
+func TestExecResultNotFound(t *testing.T) {
+	ConfigureGlobals()
+
+	res, err := New().Arg("gosh-command-that-does-not-exist").ExecResult()
+	if err == nil {
+		t.Fatal("expected command to fail, but it succeeded")
+	}
+	if res == nil || res.ExitCode != -1 || res.PID != 0 {
+		t.Errorf("expected result with exit code -1 and no PID, got %+v", res)
+	}
+}
@@ -0,0 +1,127 @@
+package gosh
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RetryPolicy controls how HTTPStreamWriter retries batches that could not
+// be delivered because of a network error, a 5xx response, 408 or 429.
+// Other 4xx responses are not retried.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts per batch, including the
+	// first. Values below 2 disable retries.
+	MaxAttempts int
+	// InitialBackoff is the delay before the first retry.
+	InitialBackoff time.Duration
+	// MaxBackoff caps the delay between attempts, including delays
+	// requested by a Retry-After header.
+	MaxBackoff time.Duration
+	// Multiplier is the factor by which the delay grows after each retry.
+	Multiplier float64
+	// Jitter randomizes each delay by up to this fraction in either
+	// direction, so that many writers don't retry in lockstep.
+	Jitter float64
+}
+
+// DefaultRetryPolicy is the RetryPolicy used unless WithRetryPolicy is given.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:    5,
+	InitialBackoff: 200 * time.Millisecond,
+	MaxBackoff:     30 * time.Second,
+	Multiplier:     2,
+	Jitter:         0.2,
+}
+
+// WithRetryPolicy sets the policy for retrying failed batch deliveries.
+func WithRetryPolicy(p RetryPolicy) HTTPStreamOption {
+	return func(w *HTTPStreamWriter) {
+		w.retry = p
+	}
+}
+
+// HTTPStatusError is returned when the endpoint responds with a status of 400 or above.
+type HTTPStatusError struct {
+	StatusCode int
+	Status     string
+	// RetryAfter is the delay requested by a Retry-After header on a 429
+	// or 503 response, or zero.
+	RetryAfter time.Duration
+}
+
+func (e *HTTPStatusError) Error() string {
+	return fmt.Sprintf("HTTP stream response status: %s", e.Status)
+}
+
+// delay returns how long to wait before retry number attempt (starting at 1).
+// A server-requested delay takes precedence over exponential backoff.
+func (p RetryPolicy) delay(attempt int, retryAfter time.Duration) time.Duration {
+	d := retryAfter
+	if d <= 0 {
+		backoff := float64(p.InitialBackoff) * math.Pow(p.Multiplier, float64(attempt-1))
+		if p.Jitter > 0 {
+			backoff *= 1 + p.Jitter*(2*rand.Float64()-1)
+		}
+		// Clamp before converting, as the backoff soon outgrows time.Duration
+		switch {
+		case p.MaxBackoff > 0 && backoff > float64(p.MaxBackoff):
+			d = p.MaxBackoff
+		case backoff >= math.MaxInt64:
+			d = math.MaxInt64
+		default:
+			d = time.Duration(backoff)
+		}
+	}
+	if p.MaxBackoff > 0 && d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+	return d
+}
+
+// errCreateRequest wraps failures to build the request, such as an invalid
+// URL, which no retry can fix.
+var errCreateRequest = errors.New("creating request")
+
+// retryable reports whether a failed delivery may succeed if attempted again.
+func retryable(err error) bool {
+	var statusErr *HTTPStatusError
+	if errors.As(err, &statusErr) {
+		switch code := statusErr.StatusCode; {
+		case code == http.StatusRequestTimeout, code == http.StatusTooManyRequests:
+			return true
+		case code == http.StatusNotImplemented, code == http.StatusHTTPVersionNotSupported:
+			return false
+		default:
+			return code >= 500
+		}
+	}
+	if errors.Is(err, ErrEncoding) || errors.Is(err, errCreateRequest) {
+		return false
+	}
+	// Anything else is a transport error such as a refused or reset connection
+	return !errors.Is(err, context.Canceled)
+}
+
+// parseRetryAfter parses a Retry-After header given either as a number of
+// seconds or as an HTTP date. It returns zero if the header is absent or invalid.
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil && at.After(now) {
+		return at.Sub(now)
+	}
+	return 0
+}
@@ -0,0 +1,147 @@
+package gosh
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fastRetry retries quickly so tests don't wait on real backoff.
+var fastRetry = RetryPolicy{
+	MaxAttempts:    4,
+	InitialBackoff: time.Millisecond,
+	MaxBackoff:     5 * time.Second,
+	Multiplier:     2,
+}
+
+func TestHTTPStreamWriterRetriesServerErrors(t *testing.T) {
+	var attempts atomic.Int32
+	var delivered atomic.Value
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if attempts.Add(1) <= 2 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		delivered.Store(string(body))
+	}))
+	defer srv.Close()
+
+	w := NewHTTPStreamWriter(srv.URL, nil, WithRetryPolicy(fastRetry))
+	io.WriteString(w, "{\"msg\":\"keep me\"}\n")
+	w.Close()
+
+	if n := attempts.Load(); n != 3 {
+		t.Errorf("expected 3 attempts, got %d", n)
+	}
+	if body, _ := delivered.Load().(string); body != "{\"seq\":1,\"msg\":\"keep me\"}\n" {
+		t.Errorf("expected the line to be delivered after retries, got %q", body)
+	}
+}
+
+func TestHTTPStreamWriterDoesNotRetryClientErrors(t *testing.T) {
+	var attempts atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts.Add(1)
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer srv.Close()
+
+	w := NewHTTPStreamWriter(srv.URL, nil, WithRetryPolicy(fastRetry))
+	io.WriteString(w, "{\"msg\":\"bad\"}\n")
+	w.Close()
+
+	if n := attempts.Load(); n != 1 {
+		t.Errorf("expected a single attempt for a 400 response, got %d", n)
+	}
+}
+
+func TestHTTPStreamWriterDoesNotRetryInvalidRequests(t *testing.T) {
+	dir := t.TempDir()
+	slowRetry := RetryPolicy{MaxAttempts: 5, InitialBackoff: time.Minute, Multiplier: 2}
+	w := NewHTTPStreamWriter("http://bad host/logs", nil, WithRetryPolicy(slowRetry), WithSpool(dir, 1<<20, SpoolDropOldest))
+	io.WriteString(w, "{\"msg\":\"lost\"}\n")
+
+	start := time.Now()
+	if err := w.Close(); err == nil {
+		t.Error("expected a delivery error for an invalid URL")
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("expected no retries for an invalid URL, took %s", elapsed)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("expected nothing to be spooled, got %d files", len(entries))
+	}
+}
+
+func TestHTTPStreamWriterHonorsRetryAfter(t *testing.T) {
+	var attempts atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if attempts.Add(1) == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+		}
+	}))
+	defer srv.Close()
+
+	w := NewHTTPStreamWriter(srv.URL, nil, WithRetryPolicy(fastRetry))
+	start := time.Now()
+	io.WriteString(w, "{\"msg\":\"slow down\"}\n")
+	w.Close()
+
+	if n := attempts.Load(); n != 2 {
+		t.Errorf("expected 2 attempts, got %d", n)
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("expected the retry to wait for Retry-After, took %v", elapsed)
+	}
+}
+
+func TestRetryPolicyDelay(t *testing.T) {
+	p := RetryPolicy{InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second, Multiplier: 2, Jitter: 0.5}
+
+	for attempt, base := range map[int]time.Duration{1: 100 * time.Millisecond, 3: 400 * time.Millisecond} {
+		d := p.delay(attempt, 0)
+		if d < base/2 || d > base*3/2 {
+			t.Errorf("delay(%d) = %v, want within 50%% of %v", attempt, d, base)
+		}
+	}
+	if d := p.delay(10, 0); d != time.Second {
+		t.Errorf("expected delay to be capped at MaxBackoff, got %v", d)
+	}
+	for _, attempt := range []int{37, 100, 10000} {
+		if d := p.delay(attempt, 0); d != time.Second {
+			t.Errorf("expected delay(%d) to be capped at MaxBackoff, got %v", attempt, d)
+		}
+		if d := DefaultRetryPolicy.delay(attempt, 0); d != DefaultRetryPolicy.MaxBackoff {
+			t.Errorf("expected default delay(%d) to be capped at MaxBackoff, got %v", attempt, d)
+		}
+	}
+	uncapped := RetryPolicy{InitialBackoff: time.Second, Multiplier: 2}
+	if d := uncapped.delay(100, 0); d <= 0 {
+		t.Errorf("expected an uncapped delay not to overflow, got %v", d)
+	}
+	if d := p.delay(1, 700*time.Millisecond); d != 700*time.Millisecond {
+		t.Errorf("expected Retry-After to take precedence, got %v", d)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	testCases := map[string]time.Duration{
+		"":                              0,
+		"3":                             3 * time.Second,
+		"-1":                            0,
+		"soon":                          0,
+		"Wed, 01 Jan 2025 00:00:10 GMT": 10 * time.Second,
+	}
+	for value, want := range testCases {
+		if got := parseRetryAfter(value, now); got != want {
+			t.Errorf("parseRetryAfter(%q) = %v, want %v", value, got, want)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package gosh
+
+import "sync"
+
+// RingBuffer is a Sink that keeps the most recent log lines in memory, for
+// example to attach the end of a build log to a failure report. It never
+// fails, and its lines remain available after the Shell closes it.
+type RingBuffer struct {
+	mutex sync.Mutex
+	lines lineWriter
+	ring  []string
+	next  int // index of the oldest line once the ring is full
+}
+
+// NewRingBuffer returns a RingBuffer holding up to n lines.
+func NewRingBuffer(n int) *RingBuffer {
+	r := &RingBuffer{ring: make([]string, 0, n)}
+	r.lines.fn = r.add
+	return r
+}
+
+// Write implements io.Writer interface
+func (r *RingBuffer) Write(p []byte) (int, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.lines.Write(p)
+}
+
+// Flush adds a buffered partial line, if any.
+func (r *RingBuffer) Flush() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.lines.Flush()
+	return nil
+}
+
+// Close implements Sink. It flushes a partial line and keeps the lines.
+func (r *RingBuffer) Close() error {
+	return r.Flush()
+}
+
+// Lines returns the buffered lines, oldest first.
+func (r *RingBuffer) Lines() []string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return append(append([]string(nil), r.ring[r.next:]...), r.ring[:r.next]...)
+}
+
+func (r *RingBuffer) add(line string) {
+	if cap(r.ring) == 0 {
+		return
+	}
+	if len(r.ring) < cap(r.ring) {
+		r.ring = append(r.ring, line)
+		return
+	}
+	r.ring[r.next] = line
+	r.next = (r.next + 1) % len(r.ring)
+}
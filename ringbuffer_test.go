@@ -0,0 +1,21 @@
+package gosh
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestRingBuffer(t *testing.T) {
+	r := NewRingBuffer(3)
+	for i := 1; i <= 5; i++ {
+		fmt.Fprintf(r, "line %d\n", i)
+	}
+	r.Write([]byte("partial"))
+	r.Close()
+
+	want := []string{"line 4", "line 5", "partial"}
+	if got := r.Lines(); strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("expected lines %q, got %q", want, got)
+	}
+}
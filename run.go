@@ -0,0 +1,360 @@
+package gosh
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/rs/zerolog"
+)
+
+// errNoCommand is returned when Exec or Stream is called before a command is set.
+var errNoCommand = errors.New("no command specified - use Arg() or Command() to set the command")
+
+// buildCmds creates an exec.Cmd for every stage of the command.
+func (s *Shell) buildCmds() []*exec.Cmd {
+	stages := s.stages()
+	cmds := make([]*exec.Cmd, len(stages))
+	for i, st := range stages {
+		cmds[i] = s.buildCmd(st)
+	}
+	return cmds
+}
+
+// buildCmd creates the exec.Cmd for the command, directory and environment
+// of stage, including the trace context started by startSpan. The command
+// runs in its own process group so that the whole process tree can be
+// signalled, and Wait gives up on output pipes held open by descendants
+// once the wait delay has elapsed after the command exits.
+func (s *Shell) buildCmd(stage *Shell) *exec.Cmd {
+	cmd := exec.Command(stage.command, stage.args...)
+	setProcessGroup(cmd)
+	cmd.WaitDelay = s.waitDelay
+
+	if stage.dir != "" {
+		cmd.Dir = stage.dir
+	}
+	env := stage.env
+	if s.traceParent != "" {
+		env = append(env[:len(env):len(env)], TraceParentEnv+"="+s.traceParent)
+	}
+	if len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
+	return cmd
+}
+
+// Outcome describes how a command that had to be stopped by gosh ended.
+type Outcome string
+
+const (
+	// OutcomeTimedOut is logged when the timeout fires, before the command is signalled.
+	OutcomeTimedOut Outcome = "timed_out"
+	// OutcomeAborted is logged when the output matches an AbortOn pattern,
+	// before the command is signalled.
+	OutcomeAborted Outcome = "aborted"
+	// OutcomeTerminated means the command exited within the grace period after
+	// SIGTERM, or had already exited by the time it was to be signalled.
+	OutcomeTerminated Outcome = "terminated"
+	// OutcomeKilled means the command had to be killed with SIGKILL.
+	OutcomeKilled Outcome = "killed"
+)
+
+// stopReason records why the supervisor stopped a command, if it did.
+type stopReason struct {
+	timedOut  bool
+	cancelled bool
+	aborted   *AbortError
+	outcome   Outcome
+}
+
+// run starts cmds, the stages of a pipeline or a single command, and waits
+// for them to finish. If the timeout elapses or ctx is done first, the
+// processes are stopped with SIGTERM and, after the grace period, SIGKILL.
+// The outcome is logged and reflected in the returned error: a
+// *TimeoutError on timeout, or an error wrapping ctx.Err() on cancellation.
+// The returned Result is never nil; its output fields are left for the caller,
+// which logs the command_finished event once the output has been logged.
+func (s *Shell) run(ctx context.Context, cmds []*exec.Cmd) (*Result, error) {
+	cmd := cmds[0]
+	res := &Result{Path: cmd.Path, ExitCode: -1}
+
+	if err := ctx.Err(); err != nil {
+		s.logCancelled(err)
+		return res, fmt.Errorf("%w before start: %w", ErrCancelled, err)
+	}
+
+	var stdin *stdinCopy
+	if s.stdin != nil {
+		input, err := s.stdin(ctx)
+		if err == nil {
+			defer input.Close()
+			stdin, err = connectStdin(cmd, input)
+		}
+		if err != nil {
+			err = fmt.Errorf("opening stdin: %w", err)
+			s.logFailedToStart(err)
+			return res, err
+		}
+	}
+
+	copies, err := s.redirectOutput(cmds)
+	if err != nil {
+		stdin.close()
+		s.logFailedToStart(err)
+		return res, err
+	}
+
+	res.StartTime = time.Now()
+	if err := startPipeline(cmds); err != nil {
+		stdin.close()
+		copies.close()
+		res.EndTime = time.Now()
+		s.logFailedToStart(err)
+		if errors.Is(err, exec.ErrNotFound) || errors.Is(err, fs.ErrNotExist) {
+			return res, fmt.Errorf("failed to start command: %w: %w", ErrNotFound, err)
+		}
+		return res, fmt.Errorf("failed to start command: %w", err)
+	}
+	res.PID = cmd.Process.Pid
+	stdin.start()
+	s.logStarted(cmds)
+
+	done := make(chan struct{})
+	stopped := make(chan stopReason, 1)
+	go s.supervise(ctx, cmd.Process, done, stopped)
+
+	errs := make([]error, len(cmds))
+	orphans := false
+	for i, c := range cmds {
+		errs[i] = c.Wait()
+		if heldOutput(c, errs[i]) {
+			orphans = true
+			errs[i] = nil
+		}
+	}
+	close(done)
+	stop := <-stopped
+
+	res.EndTime = time.Now()
+	res.Duration = res.EndTime.Sub(res.StartTime)
+	res.TimedOut = stop.timedOut
+	res.StdinBytes = stdin.close()
+	exit := s.exitStage(cmds, errs)
+	if ps := cmds[exit].ProcessState; ps != nil {
+		res.ExitCode = ps.ExitCode()
+		res.Signal = exitSignal(ps)
+	}
+	if len(cmds) > 1 {
+		res.Stages = s.stageResults(cmds)
+	}
+	err = errs[exit]
+	if copyErr := copies.close(); copyErr != nil && err == nil {
+		err = copyErr
+	}
+
+	if orphans {
+		// A stage exited but descendants kept its output pipes open. They
+		// would otherwise outlive us writing into closed pipes, so the rest
+		// of the group is killed.
+		killProcess(cmd.Process)
+		s.withLogKVs(s.log.Warn()).
+			Str("event", "orphans_killed").
+			Msg("command exited but its descendants held the output open")
+	} else if stop.timedOut || stop.cancelled || stop.aborted != nil {
+		// Sweep descendants that ignored SIGTERM after the leader exited
+		killProcess(cmd.Process)
+	}
+	reapOrphans(cmd.Process.Pid)
+
+	switch {
+	case stop.aborted != nil:
+		stop.aborted.Outcome = stop.outcome
+		return res, stop.aborted
+	case stop.timedOut:
+		return res, &TimeoutError{Timeout: s.timeout, Outcome: stop.outcome, Err: err}
+	case stop.cancelled:
+		return res, fmt.Errorf("%w and %s: %w", ErrCancelled, stop.outcome, ctx.Err())
+	}
+	return res, err
+}
+
+// heldOutput reports whether err, returned by the Wait of c, means that
+// descendants of c held its output pipes open past the wait delay. Wait
+// also returns exec.ErrWaitDelay when it gives up on copying a stdin that
+// is not an *os.File, which says nothing about descendants; run never
+// passes such a stdin, so only the stdout and stderr copies are left.
+func heldOutput(c *exec.Cmd, err error) bool {
+	if !errors.Is(err, exec.ErrWaitDelay) {
+		return false
+	}
+	_, isFile := c.Stdin.(*os.File)
+	return c.Stdin == nil || isFile
+}
+
+// supervise waits for the command to finish, the timeout to elapse or ctx to
+// be done, whichever comes first, and stops the process in the latter two
+// cases. It sends exactly one stopReason on stopped before returning.
+func (s *Shell) supervise(ctx context.Context, p *os.Process, done <-chan struct{}, stopped chan<- stopReason) {
+	var stop stopReason
+
+	var timeoutC <-chan time.Time
+	if s.timeout > 0 {
+		timer := time.NewTimer(s.timeout)
+		defer timer.Stop()
+		timeoutC = timer.C
+	}
+
+	select {
+	case <-done:
+		stopped <- stop
+		return
+	case <-ctx.Done():
+		if stop.aborted = abortCause(ctx); stop.aborted != nil {
+			s.logOutcome(OutcomeAborted, s.log.Warn()).
+				Str("pattern", stop.aborted.Match.Pattern.String()).
+				Str("line", s.mask(stop.aborted.Match.Line.Text)).
+				Msg("command aborted")
+			break
+		}
+		stop.cancelled = true
+		s.logCancelled(ctx.Err())
+	case <-timeoutC:
+		stop.timedOut = true
+		s.logOutcome(OutcomeTimedOut, s.log.Warn()).
+			Dur("timeout", s.timeout).
+			Msg("command timed out")
+	}
+
+	stop.outcome = s.terminate(p, done)
+	s.logOutcome(stop.outcome, s.log.Warn()).Msg("command " + string(stop.outcome))
+	stopped <- stop
+}
+
+// terminate sends SIGTERM to the process group of p and waits up to the
+// grace period for it to exit (signalled by done) before sending SIGKILL.
+// A group that is already gone was not killed, so it counts as terminated.
+func (s *Shell) terminate(p *os.Process, done <-chan struct{}) Outcome {
+	if s.gracePeriod > 0 {
+		err := terminateProcess(p)
+		if errors.Is(err, os.ErrProcessDone) {
+			return OutcomeTerminated
+		}
+		if err == nil {
+			grace := time.NewTimer(s.gracePeriod)
+			defer grace.Stop()
+			select {
+			case <-done:
+				return OutcomeTerminated
+			case <-grace.C:
+			}
+		}
+	}
+	if errors.Is(killProcess(p), os.ErrProcessDone) {
+		return OutcomeTerminated
+	}
+	return OutcomeKilled
+}
+
+// logCancelled logs a structured "cancelled" event with the configured log KVs.
+func (s *Shell) logCancelled(cause error) {
+	s.withLogKVs(s.log.Warn()).
+		Str("event", "cancelled").
+		Str("error", cause.Error()).
+		Msg("command cancelled")
+}
+
+// logOutcome starts a log event for a stop outcome with the configured log KVs.
+func (s *Shell) logOutcome(outcome Outcome, e *zerolog.Event) *zerolog.Event {
+	return s.withLogKVs(e).Str("event", string(outcome))
+}
+
+// withLogKVs adds the configured log KVs, and the trace context of the
+// running command if spans are exported, to a log event.
+func (s *Shell) withLogKVs(e *zerolog.Event) *zerolog.Event {
+	for k, v := range s.logKVs {
+		e = e.Str(k, v)
+	}
+	return s.withTraceContext(e)
+}
+
+// closeSinks flushes and closes the sinks, if any, and records whether
+// every line was delivered.
+func (s *Shell) closeSinks() {
+	if s.sinks != nil {
+		s.deliveryErr = s.sinks.close()
+	}
+	s.closeTraces()
+}
+
+// maxLineLength is the longest partial line a lineWriter buffers. A longer
+// line, such as a progress bar redrawn with \r, is emitted in pieces.
+const maxLineLength = 64 * 1024
+
+// lineWriter is an io.Writer that calls fn for every non-empty line written
+// to it. Partial lines are buffered until the newline arrives, they reach
+// maxLineLength or Flush is called.
+type lineWriter struct {
+	fn  func(line string)
+	buf []byte
+}
+
+// Write implements io.Writer interface
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	start := 0
+	for {
+		i := bytes.IndexByte(w.buf[start:], '\n')
+		if i < 0 {
+			break
+		}
+		w.emit(w.buf[start : start+i])
+		start += i + 1
+	}
+	for len(w.buf)-start >= maxLineLength {
+		n := pieceLength(w.buf[start : start+maxLineLength])
+		w.emit(w.buf[start : start+n])
+		start += n
+	}
+	if start > 0 {
+		// Copy the partial line so the consumed prefix can be garbage collected
+		w.buf = bytes.Clone(w.buf[start:])
+	}
+	return len(p), nil
+}
+
+// pieceLength returns the length of the piece of an overlong line to emit
+// from b, backing off so as not to split a multi-byte character.
+func pieceLength(b []byte) int {
+	for i := len(b) - 1; i >= 0 && i >= len(b)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(b[i]) {
+			if i > 0 && !utf8.FullRune(b[i:]) {
+				return i
+			}
+			break
+		}
+	}
+	return len(b)
+}
+
+// Flush emits any buffered partial line.
+func (w *lineWriter) Flush() {
+	if len(w.buf) > 0 {
+		w.emit(w.buf)
+		w.buf = nil
+	}
+}
+
+func (w *lineWriter) emit(b []byte) {
+	line := strings.TrimSuffix(string(b), "\r")
+	if line != "" {
+		w.fn(line)
+	}
+}
@@ -0,0 +1,172 @@
+package gosh
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+func TestExecContextCancel(t *testing.T) {
+	ConfigureGlobals()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	var err error
+	start := time.Now()
+	logOutput := captureOutput(func() {
+		_, err = New().LogKV("deploymentId", "d1").Args("sleep", "10").ExecContext(ctx)
+	})
+
+	if err == nil {
+		t.Fatal("expected command to be cancelled, but it succeeded")
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected error to wrap context.DeadlineExceeded, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("expected command to be killed promptly, took %v", elapsed)
+	}
+	if !strings.Contains(logOutput, `"event":"cancelled"`) || !strings.Contains(logOutput, `"deploymentId":"d1"`) {
+		t.Errorf("expected cancelled event with log KVs, got %q", logOutput)
+	}
+}
+
+func TestStreamContextCancelFlushesHTTP(t *testing.T) {
+	ConfigureGlobals()
+
+	var mu sync.Mutex
+	var received strings.Builder
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		received.Write(body)
+		mu.Unlock()
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(300*time.Millisecond, cancel)
+
+	err := New().
+		WithHTTPStreamOnly(srv.URL).
+		Command("sh").
+		Args("-c", "echo before-cancel; exec sleep 10").
+		StreamContext(ctx)
+
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected error to wrap context.Canceled, got %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if !strings.Contains(received.String(), "before-cancel") {
+		t.Errorf("expected output produced before cancellation to be delivered, got %q", received.String())
+	}
+	if !strings.Contains(received.String(), `"event":"cancelled"`) {
+		t.Errorf("expected cancelled event to be delivered, got %q", received.String())
+	}
+}
+
+func TestLineWriter(t *testing.T) {
+	var lines []string
+	w := &lineWriter{fn: func(line string) { lines = append(lines, line) }}
+
+	w.Write([]byte("one\r\ntw"))
+	w.Write([]byte("o\n\nthree"))
+	w.Flush()
+
+	want := []string{"one", "two", "three"}
+	if strings.Join(lines, "|") != strings.Join(want, "|") {
+		t.Errorf("expected lines %q, got %q", want, lines)
+	}
+}
+
+func TestLineWriterLongLines(t *testing.T) {
+	var lines []string
+	w := &lineWriter{fn: func(line string) { lines = append(lines, line) }}
+
+	// A progress bar that never ends its line, written in small chunks
+	chunk := []byte(strings.Repeat("#", 99) + "\r")
+	for range 5 * maxLineLength / len(chunk) {
+		w.Write(chunk)
+	}
+	if len(lines) != 4 || len(lines[0]) != maxLineLength {
+		t.Fatalf("expected the line to be emitted in pieces of %d bytes, got %d pieces", maxLineLength, len(lines))
+	}
+	if len(w.buf) >= maxLineLength {
+		t.Errorf("expected the buffered partial line to stay under %d bytes, got %d", maxLineLength, len(w.buf))
+	}
+
+	lines = nil
+	w = &lineWriter{fn: func(line string) { lines = append(lines, line) }}
+	w.Write([]byte("a" + strings.Repeat("é", maxLineLength)))
+	w.Flush()
+	for i, line := range lines {
+		if !utf8.ValidString(line) {
+			t.Errorf("expected piece %d to be valid UTF-8", i)
+		}
+	}
+	if got := strings.Join(lines, ""); got != "a"+strings.Repeat("é", maxLineLength) {
+		t.Errorf("expected the pieces to add up to the line, got %d bytes", len(got))
+	}
+}
+
+func TestTimeoutTerminated(t *testing.T) {
+	ConfigureGlobals()
+
+	var err error
+	logOutput := captureOutput(func() {
+		err = New().Args("sleep", "10").Timeout(100 * time.Millisecond).Stream()
+	})
+
+	if !errors.Is(err, ErrTimeout) {
+		t.Fatalf("expected error to match ErrTimeout, got %v", err)
+	}
+	var timeoutErr *TimeoutError
+	if !errors.As(err, &timeoutErr) {
+		t.Fatalf("expected *TimeoutError, got %T", err)
+	}
+	if timeoutErr.Outcome != OutcomeTerminated {
+		t.Errorf("expected outcome %q, got %q", OutcomeTerminated, timeoutErr.Outcome)
+	}
+	if !strings.Contains(logOutput, `"event":"timed_out"`) || !strings.Contains(logOutput, `"event":"terminated"`) {
+		t.Errorf("expected timed_out and terminated events, got %q", logOutput)
+	}
+}
+
+func TestTimeoutKilledAfterGracePeriod(t *testing.T) {
+	ConfigureGlobals()
+
+	var err error
+	start := time.Now()
+	logOutput := captureOutput(func() {
+		_, err = New().
+			Command("sh").
+			Args("-c", "trap '' TERM; while :; do sleep 0.05; done").
+			Timeout(100 * time.Millisecond).
+			GracePeriod(200 * time.Millisecond).
+			Exec()
+	})
+
+	var timeoutErr *TimeoutError
+	if !errors.As(err, &timeoutErr) {
+		t.Fatalf("expected *TimeoutError, got %v", err)
+	}
+	if timeoutErr.Outcome != OutcomeKilled {
+		t.Errorf("expected outcome %q, got %q", OutcomeKilled, timeoutErr.Outcome)
+	}
+	if elapsed := time.Since(start); elapsed < 300*time.Millisecond {
+		t.Errorf("expected SIGKILL only after the grace period, took %v", elapsed)
+	}
+	if !strings.Contains(logOutput, `"event":"killed"`) {
+		t.Errorf("expected killed event, got %q", logOutput)
+	}
+}
@@ -0,0 +1,86 @@
+package gosh
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestHMACSigning(t *testing.T) {
+	secret := []byte("shared-secret")
+
+	var mu sync.Mutex
+	var verifyErrs []error
+	nonces := make(map[string]bool)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		err := VerifyHMAC(secret, r.Header, body, time.Now(), time.Minute)
+		mu.Lock()
+		defer mu.Unlock()
+		verifyErrs = append(verifyErrs, err)
+		if nonces[r.Header.Get(NonceHeader)] {
+			t.Error("expected a fresh nonce for every request")
+		}
+		nonces[r.Header.Get(NonceHeader)] = true
+	}))
+	defer srv.Close()
+
+	w := NewHTTPStreamWriter(srv.URL, nil, WithHMACSigning(secret), WithCompression(CompressionGzip, 1))
+	io.WriteString(w, "{\"msg\":\"one\"}\n")
+	w.Flush()
+	io.WriteString(w, "{\"msg\":\"two\"}\n")
+	w.Close()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(verifyErrs) != 2 {
+		t.Fatalf("expected 2 signed requests, got %d", len(verifyErrs))
+	}
+	for _, err := range verifyErrs {
+		if err != nil {
+			t.Errorf("expected signature to verify, got %v", err)
+		}
+	}
+}
+
+func TestVerifyHMAC(t *testing.T) {
+	secret := []byte("shared-secret")
+	body := []byte("{\"msg\":\"hi\"}\n")
+	now := time.Unix(1700000000, 0)
+
+	signed := func(ts time.Time, body []byte) http.Header {
+		timestamp := strconv.FormatInt(ts.Unix(), 10)
+		h := http.Header{}
+		h.Set(TimestampHeader, timestamp)
+		h.Set(NonceHeader, "abc123")
+		h.Set(SignatureHeader, SignHMAC(secret, timestamp, "abc123", body))
+		return h
+	}
+
+	testCases := []struct {
+		name   string
+		header http.Header
+		secret []byte
+		want   error
+	}{
+		{"valid", signed(now, body), secret, nil},
+		{"unsigned", http.Header{}, secret, ErrSignatureMissing},
+		{"tampered body", signed(now, []byte("{}\n")), secret, ErrSignatureInvalid},
+		{"wrong secret", signed(now, body), []byte("other"), ErrSignatureInvalid},
+		{"expired", signed(now.Add(-10*time.Minute), body), secret, ErrSignatureExpired},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := VerifyHMAC(tc.secret, tc.header, body, now, 5*time.Minute)
+			if !errors.Is(err, tc.want) {
+				t.Errorf("expected %v, got %v", tc.want, err)
+			}
+		})
+	}
+}
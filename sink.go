@@ -0,0 +1,132 @@
+package gosh
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"sync"
+
+	"github.com/rs/zerolog"
+)
+
+// Sink is a destination for the JSON log lines of a Shell.
+//
+// Each zerolog event arrives as a single Write of one newline-terminated
+// line. Writes are serialized by the Shell. Flush pushes out anything the
+// sink buffers, and Close releases its resources. The Shell closes its sinks
+// at the end of every Exec or Stream, so a sink that is reused for the next
+// command must reopen itself on the following Write, as the built-in sinks do.
+type Sink interface {
+	io.Writer
+	Flush() error
+	Close() error
+}
+
+// SinkError reports a sink that failed to accept or deliver log lines.
+// The first write error of a run and the error from closing the sink are
+// joined in Err.
+type SinkError struct {
+	Sink Sink
+	Err  error
+}
+
+func (e *SinkError) Error() string {
+	return fmt.Sprintf("log sink %T: %v", e.Sink, e.Err)
+}
+
+func (e *SinkError) Unwrap() error {
+	return e.Err
+}
+
+// WithSink adds sinks that receive every log line alongside stdout. It can
+// be called several times, and combined with WithHTTPStream, to send logs
+// to several places at once. A sink that fails does not affect the others
+// or the command; its errors are reported by DeliveryErr.
+func (s *Shell) WithSink(sinks ...Sink) *Shell {
+	if s.sinks == nil {
+		s.sinks = &sinkWriter{console: os.Stdout}
+	}
+	s.sinks.sinks = append(s.sinks.sinks, sinks...)
+	s.sinks.errs = append(s.sinks.errs, make([]error, len(sinks))...)
+	s.log = zerolog.New(s.sinks).With().Timestamp().Logger()
+	return s
+}
+
+// WithSinkOnly is like WithSink but stops logging to stdout.
+func (s *Shell) WithSinkOnly(sinks ...Sink) *Shell {
+	s.WithSink(sinks...)
+	s.sinks.console = nil
+	return s
+}
+
+// sinkWriter fans log lines out to stdout and the sinks of a Shell, keeping
+// the first write error of each sink instead of failing the write.
+type sinkWriter struct {
+	mutex   sync.Mutex
+	console io.Writer // nil when logging only to sinks
+	sinks   []Sink
+	errs    []error // first write error of each sink since the last close
+}
+
+// Write implements io.Writer interface
+func (w *sinkWriter) Write(p []byte) (int, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.console != nil {
+		w.console.Write(p)
+	}
+	for i, sink := range w.sinks {
+		if _, err := sink.Write(p); err != nil && w.errs[i] == nil {
+			w.errs[i] = err
+		}
+	}
+	return len(p), nil
+}
+
+// logKVsSink is implemented by sinks that use the log KVs of the Shell
+// writing to them. The Shell passes its KVs at the start of every run.
+type logKVsSink interface {
+	setLogKVs(kvs map[string]string)
+}
+
+// setLogKVs passes kvs to the sinks that use them. It is a no-op on a nil
+// *sinkWriter.
+func (w *sinkWriter) setLogKVs(kvs map[string]string) {
+	if w == nil {
+		return
+	}
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	for _, sink := range w.sinks {
+		if s, ok := sink.(logKVsSink); ok {
+			s.setLogKVs(maps.Clone(kvs))
+		}
+	}
+}
+
+// close closes every sink and returns a *SinkError for each one that failed
+// since the last close, joined, or nil.
+func (w *sinkWriter) close() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	var errs []error
+	for i, sink := range w.sinks {
+		if err := errors.Join(w.errs[i], sink.Close()); err != nil {
+			errs = append(errs, &SinkError{Sink: sink, Err: err})
+		}
+		w.errs[i] = nil
+	}
+	return errors.Join(errs...)
+}
+
+var (
+	_ Sink = (*HTTPStreamWriter)(nil)
+	_ Sink = (*FileSink)(nil)
+	_ Sink = (*SocketSink)(nil)
+	_ Sink = (*SyslogSink)(nil)
+	_ Sink = (*RingBuffer)(nil)
+)
@@ -0,0 +1,65 @@
+package gosh
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// failingSink rejects every write.
+type failingSink struct {
+	closed int
+}
+
+func (f *failingSink) Write(p []byte) (int, error) { return 0, errors.New("disk on fire") }
+func (f *failingSink) Flush() error                { return nil }
+func (f *failingSink) Close() error                { f.closed++; return nil }
+
+func TestWithSinkFanOut(t *testing.T) {
+	ConfigureGlobals()
+
+	ring := NewRingBuffer(10)
+	path := filepath.Join(t.TempDir(), "build.log")
+	file := NewFileSink(path, 0, 0)
+	broken := &failingSink{}
+
+	shell := New().WithSinkOnly(ring, broken).WithSink(file).LogKV("deploymentId", "d1")
+	var err error
+	stdout := captureOutput(func() {
+		err = shell.Command("sh").Args("-c", "echo one; echo two >&2").Stream()
+	})
+	if err != nil {
+		t.Fatalf("expected a failing sink not to fail the command, got %v", err)
+	}
+	if stdout != "" {
+		t.Errorf("expected nothing on stdout with WithSinkOnly, got %q", stdout)
+	}
+
+	lines := ring.Lines()
+	if len(lines) != 2 || !strings.Contains(lines[0]+lines[1], `"msg":"one"`) || !strings.Contains(lines[0]+lines[1], `"msg":"two"`) {
+		t.Errorf("expected both lines in the ring buffer, got %q", lines)
+	}
+	data, _ := os.ReadFile(path)
+	if strings.Count(string(data), `"deploymentId":"d1"`) != 2 {
+		t.Errorf("expected both lines in the file, got %q", data)
+	}
+
+	var sinkErr *SinkError
+	if !errors.As(shell.DeliveryErr(), &sinkErr) || sinkErr.Sink != broken {
+		t.Fatalf("expected a *SinkError for the failing sink, got %v", shell.DeliveryErr())
+	}
+	if broken.closed != 1 {
+		t.Errorf("expected sinks to be closed after the run, got %d closes", broken.closed)
+	}
+
+	// A closed sink reopens when it is used for the next command
+	if err := New().WithSinkOnly(file).Args("echo", "three").Stream(); err != nil {
+		t.Fatal(err)
+	}
+	data, _ = os.ReadFile(path)
+	if !strings.Contains(string(data), `"msg":"three"`) {
+		t.Errorf("expected the file sink to reopen for the next command, got %q", data)
+	}
+}
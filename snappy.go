@@ -0,0 +1,80 @@
+package gosh
+
+import "encoding/binary"
+
+// snappyBlockSize is the size of the chunks the input is compressed in, so
+// that every copy offset fits the two-byte copy element.
+const snappyBlockSize = 1 << 16
+
+// snappyEncode compresses src in the snappy block format
+// (https://github.com/google/snappy/blob/main/format_description.txt), as
+// used by Loki's protobuf push API. It is a simple greedy encoder: it finds
+// matches of at least four bytes through a hash table of recent positions
+// and emits them as two-byte-offset copies, which is enough for the highly
+// repetitive JSON of log batches.
+func snappyEncode(src []byte) []byte {
+	dst := binary.AppendUvarint(make([]byte, 0, len(src)/2+16), uint64(len(src)))
+	for len(src) > 0 {
+		block := src[:min(len(src), snappyBlockSize)]
+		src = src[len(block):]
+		dst = snappyEncodeBlock(dst, block)
+	}
+	return dst
+}
+
+func snappyEncodeBlock(dst, src []byte) []byte {
+	const (
+		minMatch  = 4
+		tableBits = 14
+	)
+	var table [1 << tableBits]int32 // position + 1 of the last occurrence of a hash
+
+	literal := 0 // start of the bytes not yet emitted
+	for i := 0; i+minMatch <= len(src); {
+		v := binary.LittleEndian.Uint32(src[i:])
+		h := (v * 0x1e35a7bd) >> (32 - tableBits)
+		candidate := int(table[h]) - 1
+		table[h] = int32(i + 1)
+		if candidate < 0 || binary.LittleEndian.Uint32(src[candidate:]) != v {
+			i++
+			continue
+		}
+
+		n := minMatch
+		for i+n < len(src) && src[candidate+n] == src[i+n] {
+			n++
+		}
+		dst = snappyAppendLiteral(dst, src[literal:i])
+		dst = snappyAppendCopy(dst, i-candidate, n)
+		i += n
+		literal = i
+	}
+	return snappyAppendLiteral(dst, src[literal:])
+}
+
+// snappyAppendLiteral emits lit, of at most snappyBlockSize bytes, as a literal element.
+func snappyAppendLiteral(dst, lit []byte) []byte {
+	n := len(lit) - 1
+	switch {
+	case n < 0:
+		return dst
+	case n < 60:
+		dst = append(dst, byte(n)<<2)
+	case n < 1<<8:
+		dst = append(dst, 60<<2, byte(n))
+	default:
+		dst = append(dst, 61<<2, byte(n), byte(n>>8))
+	}
+	return append(dst, lit...)
+}
+
+// snappyAppendCopy emits copy elements with a two-byte offset, which hold
+// up to 64 bytes each.
+func snappyAppendCopy(dst []byte, offset, length int) []byte {
+	for length > 0 {
+		n := min(length, 64)
+		dst = append(dst, byte(n-1)<<2|2, byte(offset), byte(offset>>8))
+		length -= n
+	}
+	return dst
+}
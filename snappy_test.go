@@ -0,0 +1,100 @@
+package gosh
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// snappyDecode decodes the snappy block format, for checking snappyEncode
+// and the bodies received by test servers.
+func snappyDecode(src []byte) ([]byte, error) {
+	n, k := binary.Uvarint(src)
+	if k <= 0 {
+		return nil, errors.New("bad length")
+	}
+	src = src[k:]
+	dst := make([]byte, 0, n)
+	for len(src) > 0 {
+		tag := src[0]
+		switch tag & 3 {
+		case 0:
+			length := int(tag>>2) + 1
+			src = src[1:]
+			if extra := int(tag>>2) - 59; extra > 0 {
+				if len(src) < extra {
+					return nil, errors.New("truncated literal length")
+				}
+				length = 1
+				for i := extra - 1; i >= 0; i-- {
+					length += int(src[i]) << (8 * i)
+				}
+				src = src[extra:]
+			}
+			if len(src) < length {
+				return nil, errors.New("truncated literal")
+			}
+			dst = append(dst, src[:length]...)
+			src = src[length:]
+		case 2:
+			if len(src) < 3 {
+				return nil, errors.New("truncated copy")
+			}
+			length := int(tag>>2) + 1
+			offset := int(src[1]) | int(src[2])<<8
+			if offset == 0 || offset > len(dst) {
+				return nil, fmt.Errorf("bad offset %d", offset)
+			}
+			for i := 0; i < length; i++ {
+				dst = append(dst, dst[len(dst)-offset])
+			}
+			src = src[3:]
+		default:
+			return nil, fmt.Errorf("unexpected element type %d", tag&3)
+		}
+	}
+	if uint64(len(dst)) != n {
+		return nil, fmt.Errorf("expected %d bytes, got %d", n, len(dst))
+	}
+	return dst, nil
+}
+
+func TestSnappyRoundTrip(t *testing.T) {
+	var logs strings.Builder
+	for i := 0; i < 5000; i++ {
+		fmt.Fprintf(&logs, "{\"level\":\"info\",\"deploymentId\":\"d1\",\"seq\":%d,\"msg\":\"Step %d/20 : RUN make\"}\n", i, i%20)
+	}
+
+	tests := map[string][]byte{
+		"empty":    {},
+		"short":    []byte("abc"),
+		"repeated": bytes.Repeat([]byte("a"), 1000),
+		"logs":     []byte(logs.String()),
+		"incompressible": func() []byte {
+			b := make([]byte, 3*snappyBlockSize)
+			for i := range b {
+				b[i] = byte(i * 7919 >> 3)
+			}
+			return b
+		}(),
+	}
+	for name, src := range tests {
+		t.Run(name, func(t *testing.T) {
+			encoded := snappyEncode(src)
+			decoded, err := snappyDecode(encoded)
+			if err != nil {
+				t.Fatalf("failed to decode: %v", err)
+			}
+			if !bytes.Equal(decoded, src) {
+				t.Fatalf("round trip changed the input")
+			}
+		})
+	}
+
+	if encoded := snappyEncode([]byte(logs.String())); len(encoded) > logs.Len()/3 {
+		t.Errorf("expected log lines to compress well, got %d of %d bytes", len(encoded), logs.Len())
+	}
+}
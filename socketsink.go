@@ -0,0 +1,123 @@
+package gosh
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// DefaultSocketTimeout bounds connecting to and writing to the socket of a
+// SocketSink or SyslogSink, so a stuck reader cannot stall the command.
+const DefaultSocketTimeout = 5 * time.Second
+
+// After a failed write, a SocketSink or SyslogSink drops lines without
+// reconnecting for a backoff that doubles from socketMinBackoff up to
+// socketMaxBackoff while the socket stays unavailable.
+const (
+	socketMinBackoff = time.Second
+	socketMaxBackoff = 30 * time.Second
+)
+
+// SocketSink is a Sink that writes log lines, unchanged, to a Unix domain
+// socket, for example one served by a local log agent. It connects on the
+// first write. When a write on an established connection fails, for
+// instance because the agent was restarted, it reconnects and tries once
+// more. If that fails too, or the write timed out, the line is dropped and
+// the error is reported, and further lines are dropped at once, without
+// reconnecting, for a backoff of up to 30 seconds, so a dead socket costs
+// the command at most one timeout per backoff.
+type SocketSink struct {
+	mutex sync.Mutex
+	conn  reconnectingConn
+}
+
+// NewUnixSocketSink returns a SocketSink writing to the stream socket at path.
+func NewUnixSocketSink(path string) *SocketSink {
+	return &SocketSink{conn: reconnectingConn{network: "unix", address: path}}
+}
+
+// Write implements io.Writer interface
+func (s *SocketSink) Write(p []byte) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err := s.conn.write(p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Flush implements Sink. Writes are not buffered, so there is nothing to do.
+func (s *SocketSink) Flush() error {
+	return nil
+}
+
+// Close closes the connection. The next write reconnects, unless a
+// reconnect backoff is in progress.
+func (s *SocketSink) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.conn.close()
+}
+
+// reconnectingConn is a lazily dialled connection that is redialled once when
+// a write on it fails, and backs off reconnecting when that fails too. It is
+// not safe for concurrent use.
+type reconnectingConn struct {
+	network string
+	address string
+	conn    net.Conn
+
+	backoff time.Duration
+	retryAt time.Time // no reconnecting before this time
+	lastErr error
+}
+
+// write writes b in full, reconnecting and retrying once if an established
+// connection broke. During a backoff b is dropped without reconnecting.
+func (c *reconnectingConn) write(b []byte) error {
+	if c.conn == nil && time.Now().Before(c.retryAt) {
+		return fmt.Errorf("socket unavailable, line dropped: %w", c.lastErr)
+	}
+	established := c.conn != nil
+	err := c.writeOnce(b)
+	var netErr net.Error
+	if err != nil && established && !(errors.As(err, &netErr) && netErr.Timeout()) {
+		err = c.writeOnce(b)
+	}
+	if err != nil {
+		c.backoff = min(max(2*c.backoff, socketMinBackoff), socketMaxBackoff)
+		c.retryAt = time.Now().Add(c.backoff)
+		c.lastErr = err
+		return err
+	}
+	c.backoff = 0
+	return nil
+}
+
+func (c *reconnectingConn) writeOnce(b []byte) error {
+	if c.conn == nil {
+		conn, err := net.DialTimeout(c.network, c.address, DefaultSocketTimeout)
+		if err != nil {
+			return err
+		}
+		c.conn = conn
+	}
+	c.conn.SetWriteDeadline(time.Now().Add(DefaultSocketTimeout))
+	if _, err := c.conn.Write(b); err != nil {
+		c.close()
+		return err
+	}
+	return nil
+}
+
+func (c *reconnectingConn) close() error {
+	if c.conn == nil {
+		return nil
+	}
+	err := c.conn.Close()
+	c.conn = nil
+	return err
+}
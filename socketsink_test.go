@@ -0,0 +1,106 @@
+package gosh
+
+import (
+	"bufio"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// acceptLines accepts one connection on l and sends the lines read from it on the returned channel.
+func acceptLines(t *testing.T, l net.Listener) <-chan string {
+	t.Helper()
+	lines := make(chan string, 10)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		scanner := bufio.NewScanner(conn)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+	}()
+	return lines
+}
+
+func receiveLine(t *testing.T, lines <-chan string) string {
+	t.Helper()
+	select {
+	case line := <-lines:
+		return line
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for a line")
+		return ""
+	}
+}
+
+func TestUnixSocketSinkReconnects(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "agent.sock")
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+
+	s := NewUnixSocketSink(path)
+	defer s.Close()
+	lines := acceptLines(t, l)
+	if _, err := s.Write([]byte("{\"msg\":\"one\"}\n")); err != nil {
+		t.Fatalf("expected write to succeed, got %v", err)
+	}
+	if line := receiveLine(t, lines); line != `{"msg":"one"}` {
+		t.Errorf("expected the line unchanged, got %q", line)
+	}
+
+	// Restart the agent; the sink reconnects on the next write
+	l.Close()
+	s.Close()
+	l, err = net.Listen("unix", path)
+	if err != nil {
+		t.Fatalf("failed to listen again: %v", err)
+	}
+	defer l.Close()
+	lines = acceptLines(t, l)
+	if _, err := s.Write([]byte("{\"msg\":\"two\"}\n")); err != nil {
+		t.Fatalf("expected write after reconnect to succeed, got %v", err)
+	}
+	if line := receiveLine(t, lines); line != `{"msg":"two"}` {
+		t.Errorf("expected the line after reconnecting, got %q", line)
+	}
+}
+
+func TestUnixSocketSinkUnavailable(t *testing.T) {
+	s := NewUnixSocketSink(filepath.Join(t.TempDir(), "missing.sock"))
+	if _, err := s.Write([]byte("{\"msg\":\"lost\"}\n")); err == nil {
+		t.Error("expected an error when no agent is listening")
+	}
+}
+
+func TestUnixSocketSinkBacksOff(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "agent.sock")
+	s := NewUnixSocketSink(path)
+	if _, err := s.Write([]byte("{\"msg\":\"lost\"}\n")); err == nil {
+		t.Fatal("expected an error when no agent is listening")
+	}
+
+	// The agent comes up, but lines are dropped without redialling until the backoff ends
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer l.Close()
+	lines := acceptLines(t, l)
+	if _, err := s.Write([]byte("{\"msg\":\"dropped\"}\n")); err == nil {
+		t.Error("expected the line to be dropped during the backoff")
+	}
+
+	s.conn.retryAt = time.Time{}
+	if _, err := s.Write([]byte("{\"msg\":\"back\"}\n")); err != nil {
+		t.Fatalf("expected write after the backoff to succeed, got %v", err)
+	}
+	if line := receiveLine(t, lines); line != `{"msg":"back"}` {
+		t.Errorf("expected the line after the backoff, got %q", line)
+	}
+}
@@ -0,0 +1,72 @@
+package gosh
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestSplunkSink(t *testing.T) {
+	ConfigureGlobals()
+
+	var mu sync.Mutex
+	var events []map[string]any
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/services/collector/event" || r.Header.Get("Authorization") != "Splunk hec-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			io.WriteString(w, `{"text":"Invalid token","code":4}`)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		// HEC batches are concatenated JSON objects
+		dec := json.NewDecoder(bytes.NewReader(body))
+		mu.Lock()
+		defer mu.Unlock()
+		for dec.More() {
+			var ev map[string]any
+			if err := dec.Decode(&ev); err != nil {
+				t.Errorf("invalid HEC event: %v", err)
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			events = append(events, ev)
+		}
+		io.WriteString(w, `{"text":"Success","code":0}`)
+	}))
+	defer srv.Close()
+
+	w := NewSplunkSink(srv.URL, SplunkOptions{
+		Token:         "hec-token",
+		Index:         "deploys",
+		SourceType:    "_json",
+		IndexedFields: []string{"deploymentId"},
+	})
+	io.WriteString(w, "{\"level\":\"info\",\"deploymentId\":\"d1\",\"timestamp\":1700000000,\"msg\":\"built\"}\n")
+	io.WriteString(w, "plain\n")
+	if err := w.Close(); err != nil {
+		t.Fatalf("expected delivery to succeed, got %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(events) != 2 {
+		t.Fatalf("expected 2 events, got %d", len(events))
+	}
+	ev := events[0]
+	if ev["time"] != 1700000000.0 || ev["index"] != "deploys" || ev["sourcetype"] != "_json" {
+		t.Errorf("unexpected event metadata %v", ev)
+	}
+	if event, ok := ev["event"].(map[string]any); !ok || event["msg"] != "built" {
+		t.Errorf("expected the JSON line as the event, got %v", ev["event"])
+	}
+	if fields, _ := ev["fields"].(map[string]any); fields["deploymentId"] != "d1" {
+		t.Errorf("expected deploymentId as an indexed field, got %v", ev["fields"])
+	}
+	if events[1]["event"] != "plain" {
+		t.Errorf("expected a plain line as a string event, got %v", events[1]["event"])
+	}
+}
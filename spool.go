@@ -0,0 +1,372 @@
+package gosh
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrSpoolFull is reported when spooled lines are discarded because the spool is full.
+var ErrSpoolFull = errors.New("spool full")
+
+// SpoolEviction selects what happens when a batch does not fit in the spool.
+type SpoolEviction int
+
+const (
+	// SpoolDropOldest deletes the oldest segments until the new batch fits.
+	SpoolDropOldest SpoolEviction = iota
+	// SpoolDropNewest discards the batch that does not fit.
+	SpoolDropNewest
+)
+
+const (
+	spoolPrefix = "segment-"
+	spoolSuffix = ".ndjson"
+)
+
+// WithSpool makes the writer save batches it could not deliver, after
+// retries, as segment files in dir. A background replayer sends them in
+// order once the endpoint recovers, before any newer batch, and segments
+// left over from a previous process are replayed when a writer using the
+// same directory is created. Close replays what it can but stops at the
+// first failure; segments still waiting are replayed once the writer spools
+// again, or by the next writer on the directory. The spool holds at most
+// maxBytes; eviction decides which batches are lost when it is full.
+//
+// Writers in a process that use the same directory share one spool, whose
+// size limit and eviction are those given first, and must send to the same
+// endpoint, as any of them may replay the others' batches.
+func WithSpool(dir string, maxBytes int64, eviction SpoolEviction) HTTPStreamOption {
+	return func(w *HTTPStreamWriter) {
+		w.spool, w.spoolErr = openSpool(dir, maxBytes, eviction)
+	}
+}
+
+// spool is a directory of NDJSON segment files, one per undeliverable
+// batch, named so that lexical order is delivery order.
+type spool struct {
+	dir      string
+	maxBytes int64
+	eviction SpoolEviction
+
+	mu        sync.Mutex
+	segments  []spoolSegment // oldest first
+	size      int64
+	next      uint64
+	replaying bool
+}
+
+type spoolSegment struct {
+	name string
+	size int64
+}
+
+// spools holds the open spools of the process by directory, so writers
+// sharing a directory don't reuse each other's segment names or replay
+// segments twice.
+var spools = struct {
+	sync.Mutex
+	byDir map[string]*spool
+}{byDir: make(map[string]*spool)}
+
+// openSpool returns the spool of dir, creating dir if needed and loading
+// the segments already in it when it is first opened.
+func openSpool(dir string, maxBytes int64, eviction SpoolEviction) (*spool, error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	spools.Lock()
+	defer spools.Unlock()
+	if sp := spools.byDir[abs]; sp != nil {
+		return sp, nil
+	}
+	sp, err := loadSpool(abs, maxBytes, eviction)
+	if err != nil {
+		return nil, err
+	}
+	spools.byDir[abs] = sp
+	return sp, nil
+}
+
+// loadSpool creates dir if needed and loads the segments already in it.
+func loadSpool(dir string, maxBytes int64, eviction SpoolEviction) (*spool, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	sp := &spool{dir: dir, maxBytes: maxBytes, eviction: eviction}
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasSuffix(name, ".tmp") {
+			// Left behind by a crash while writing a segment
+			os.Remove(filepath.Join(dir, name))
+			continue
+		}
+		n, ok := segmentNumber(name)
+		if !ok {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		sp.segments = append(sp.segments, spoolSegment{name: name, size: info.Size()})
+		sp.size += info.Size()
+		sp.next = max(sp.next, n+1)
+	}
+	sort.Slice(sp.segments, func(i, j int) bool { return sp.segments[i].name < sp.segments[j].name })
+	return sp, nil
+}
+
+// segmentNumber parses the sequence number out of a segment file name.
+func segmentNumber(name string) (uint64, bool) {
+	if !strings.HasPrefix(name, spoolPrefix) || !strings.HasSuffix(name, spoolSuffix) {
+		return 0, false
+	}
+	n, err := strconv.ParseUint(strings.TrimSuffix(strings.TrimPrefix(name, spoolPrefix), spoolSuffix), 10, 64)
+	return n, err == nil
+}
+
+// pending reports whether the spool holds any segments.
+func (sp *spool) pending() bool {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	return len(sp.segments) > 0
+}
+
+// append stores body as the newest segment, evicting according to the
+// eviction policy if the spool is full. It returns the number of lines
+// evicted or discarded, and whether the caller must start a replayer.
+func (sp *spool) append(body []byte) (dropped int, startReplayer bool, err error) {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+
+	size := int64(len(body))
+	if size > sp.maxBytes || (sp.eviction == SpoolDropNewest && sp.size+size > sp.maxBytes) {
+		return bytes.Count(body, []byte("\n")), false, nil
+	}
+	for sp.size+size > sp.maxBytes && len(sp.segments) > 0 {
+		oldest := sp.segments[0]
+		path := filepath.Join(sp.dir, oldest.name)
+		if data, err := os.ReadFile(path); err == nil {
+			dropped += bytes.Count(data, []byte("\n"))
+		}
+		os.Remove(path)
+		sp.segments = sp.segments[1:]
+		sp.size -= oldest.size
+	}
+
+	name := fmt.Sprintf("%s%020d%s", spoolPrefix, sp.next, spoolSuffix)
+	path := filepath.Join(sp.dir, name)
+	if err := os.WriteFile(path+".tmp", body, 0o600); err != nil {
+		return dropped, false, err
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		os.Remove(path + ".tmp")
+		return dropped, false, err
+	}
+	sp.next++
+	sp.segments = append(sp.segments, spoolSegment{name: name, size: size})
+	sp.size += size
+
+	startReplayer = !sp.replaying
+	sp.replaying = true
+	return dropped, startReplayer, nil
+}
+
+// claimReplay marks the spool as being replayed, reporting whether there
+// is anything to replay and no replayer is running yet.
+func (sp *spool) claimReplay() bool {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	if sp.replaying || len(sp.segments) == 0 {
+		return false
+	}
+	sp.replaying = true
+	return true
+}
+
+// oldest returns the oldest segment. If the spool is empty it returns false
+// and marks replaying as finished, atomically with the emptiness check, so a
+// concurrent append starts a new replayer.
+func (sp *spool) oldest() (name string, body []byte, ok bool, err error) {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	if len(sp.segments) == 0 {
+		sp.replaying = false
+		return "", nil, false, nil
+	}
+	name = sp.segments[0].name
+	body, err = os.ReadFile(filepath.Join(sp.dir, name))
+	return name, body, true, err
+}
+
+// stopReplay marks replaying as finished by a replayer that was stopped
+// before the spool was empty, so the next append starts a new one.
+func (sp *spool) stopReplay() {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	sp.replaying = false
+}
+
+// remove deletes a segment returned by oldest.
+func (sp *spool) remove(name string) {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	if len(sp.segments) == 0 || sp.segments[0].name != name {
+		// Evicted while it was being replayed
+		return
+	}
+	os.Remove(filepath.Join(sp.dir, name))
+	sp.size -= sp.segments[0].size
+	sp.segments = sp.segments[1:]
+}
+
+// replace rewrites a segment returned by oldest with body, which holds the
+// lines of the segment that remain to be sent.
+func (sp *spool) replace(name string, body []byte) {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	if len(sp.segments) == 0 || sp.segments[0].name != name {
+		return
+	}
+	path := filepath.Join(sp.dir, name)
+	if err := os.WriteFile(path+".tmp", body, 0o600); err != nil {
+		os.Remove(path + ".tmp")
+		return
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		os.Remove(path + ".tmp")
+		return
+	}
+	sp.size += int64(len(body)) - sp.segments[0].size
+	sp.segments[0].size = int64(len(body))
+}
+
+// spoolBatch saves an undeliverable body to the spool, starting the
+// replayer if one is not already running.
+func (w *HTTPStreamWriter) spoolBatch(body []byte) {
+	dropped, startReplayer, err := w.spool.append(body)
+	if err != nil {
+		lines := bytes.Count(body, []byte("\n"))
+		w.failed.Add(uint64(lines))
+		w.reportError(fmt.Errorf("spooling batch of %d lines: %w", lines, err))
+	}
+	if dropped > 0 {
+		w.dropped.Add(uint64(dropped))
+		w.reportError(fmt.Errorf("%w: %d lines dropped", ErrSpoolFull, dropped))
+	}
+	if startReplayer {
+		w.startReplayer()
+	}
+}
+
+// maxReplayFailures caps the consecutive failures the replayer backs off
+// for, so the delay stays at its maximum during a long outage.
+const maxReplayFailures = 64
+
+// startReplayer starts a replayer that runs until the spool is empty or,
+// after Close, a delivery fails.
+func (w *HTTPStreamWriter) startReplayer() {
+	w.mutex.Lock()
+	if w.replayStop == nil {
+		w.replayStop = make(chan struct{})
+	}
+	stop := w.replayStop
+	w.mutex.Unlock()
+
+	w.replayers.Add(1)
+	go func() {
+		defer w.replayers.Done()
+		w.replayer(stop)
+	}()
+}
+
+// stopReplayer stops the replayer, if any, and waits for it to exit.
+func (w *HTTPStreamWriter) stopReplayer() {
+	w.mutex.Lock()
+	stop := w.replayStop
+	w.replayStop = nil
+	w.mutex.Unlock()
+
+	if stop != nil {
+		close(stop)
+	}
+	w.replayers.Wait()
+}
+
+// replayer sends spooled segments oldest first until the spool is empty,
+// backing off according to the retry policy while the endpoint is down.
+// Once stop is closed it keeps sending while deliveries succeed, and exits
+// at the first failure.
+func (w *HTTPStreamWriter) replayer(stop <-chan struct{}) {
+	failures := 0
+	for {
+		name, body, ok, err := w.spool.oldest()
+		if !ok {
+			return
+		}
+		if err != nil {
+			w.reportError(fmt.Errorf("reading spooled segment %s: %w", name, err))
+			w.spool.remove(name)
+			continue
+		}
+
+		rest, err := w.attempt(body)
+		switch {
+		case err == nil:
+			w.spool.remove(name)
+			failures = 0
+		case !retryable(err):
+			w.failed.Add(uint64(bytes.Count(rest, []byte("\n"))))
+			w.reportError(fmt.Errorf("replaying spooled segment %s: %w", name, err))
+			w.spool.remove(name)
+		default:
+			if len(rest) < len(body) {
+				// Only the lines rejected individually are left to send
+				w.spool.replace(name, rest)
+			}
+			failures = min(failures+1, maxReplayFailures)
+			w.retried.Add(1)
+			var retryAfter time.Duration
+			var statusErr *HTTPStatusError
+			if errors.As(err, &statusErr) {
+				retryAfter = statusErr.RetryAfter
+			}
+			if !sleepUnlessStopped(w.retry.delay(failures, retryAfter), stop) {
+				w.spool.stopReplay()
+				return
+			}
+		}
+	}
+}
+
+// sleepUnlessStopped waits for d and reports true, or reports false without
+// waiting if stop is closed first.
+func sleepUnlessStopped(d time.Duration, stop <-chan struct{}) bool {
+	select {
+	case <-stop:
+		return false
+	default:
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-stop:
+		return false
+	}
+}
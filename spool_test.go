@@ -0,0 +1,231 @@
+package gosh
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// noRetry fails batches on the first error so tests reach the spool quickly.
+var noRetry = RetryPolicy{MaxAttempts: 1, InitialBackoff: 10 * time.Millisecond, MaxBackoff: 50 * time.Millisecond, Multiplier: 1}
+
+// flakyServer rejects every request with 503 until healthy is set.
+type flakyServer struct {
+	*httptest.Server
+	healthy  atomic.Bool
+	requests atomic.Int32
+
+	mu       sync.Mutex
+	received []string
+}
+
+func newFlakyServer(t *testing.T) *flakyServer {
+	t.Helper()
+	fs := &flakyServer{}
+	fs.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fs.requests.Add(1)
+		if !fs.healthy.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		fs.mu.Lock()
+		fs.received = append(fs.received, strings.Split(strings.TrimSuffix(string(body), "\n"), "\n")...)
+		fs.mu.Unlock()
+	}))
+	t.Cleanup(fs.Close)
+	return fs
+}
+
+// waitForLines waits until the server has received n lines and returns them.
+func (fs *flakyServer) waitForLines(t *testing.T, n int) []string {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		fs.mu.Lock()
+		received := append([]string(nil), fs.received...)
+		fs.mu.Unlock()
+		if len(received) >= n {
+			return received
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d lines to be delivered, got %d", n, len(received))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func segmentCount(t *testing.T, dir string) int {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("failed to read spool: %v", err)
+	}
+	return len(entries)
+}
+
+func TestSpoolReplaysInOrderAfterOutage(t *testing.T) {
+	srv := newFlakyServer(t)
+	dir := t.TempDir()
+
+	w := NewHTTPStreamWriter(srv.URL, nil,
+		WithRetryPolicy(noRetry),
+		WithMaxBatchBytes(64),
+		WithSpool(dir, 1<<20, SpoolDropOldest))
+	for i := 0; i < 10; i++ {
+		fmt.Fprintf(w, "{\"msg\":\"line %d\"}\n", i)
+	}
+	w.Close()
+
+	if segmentCount(t, dir) == 0 {
+		t.Fatal("expected undeliverable batches to be spooled")
+	}
+
+	srv.healthy.Store(true)
+	io.WriteString(w, "{\"msg\":\"line 10\"}\n")
+	w.Close()
+
+	received := srv.waitForLines(t, 11)
+	for i, line := range received {
+		if !strings.Contains(line, fmt.Sprintf("\"line %d\"", i)) {
+			t.Fatalf("line %d out of order: %s", i, line)
+		}
+	}
+	deadline := time.Now().Add(time.Second)
+	for segmentCount(t, dir) != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("expected replayed segments to be removed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSpoolReplayerStopsOnClose(t *testing.T) {
+	srv := newFlakyServer(t)
+	dir := t.TempDir()
+
+	w := NewHTTPStreamWriter(srv.URL, nil, WithRetryPolicy(noRetry), WithSpool(dir, 1<<20, SpoolDropOldest))
+	io.WriteString(w, "{\"msg\":\"during the outage\"}\n")
+	w.Close()
+
+	requests := srv.requests.Load()
+	time.Sleep(200 * time.Millisecond)
+	if n := srv.requests.Load(); n != requests {
+		t.Errorf("expected no replays after Close, got %d more requests", n-requests)
+	}
+	if segmentCount(t, dir) != 1 {
+		t.Errorf("expected the segment to stay spooled, got %d", segmentCount(t, dir))
+	}
+}
+
+func TestSpoolSharedDirectory(t *testing.T) {
+	srv := newFlakyServer(t)
+	dir := t.TempDir()
+
+	// Both writers are open while the endpoint is down
+	var writers []*HTTPStreamWriter
+	for i := 0; i < 2; i++ {
+		writers = append(writers, NewHTTPStreamWriter(srv.URL, nil,
+			WithRetryPolicy(noRetry), WithSpool(dir, 1<<20, SpoolDropOldest)))
+	}
+	for i := 0; i < 3; i++ {
+		for j, w := range writers {
+			fmt.Fprintf(w, "{\"msg\":\"writer %d line %d\"}\n", j, i)
+			w.Flush()
+		}
+	}
+	for _, w := range writers {
+		w.Close()
+	}
+	if n := segmentCount(t, dir); n != 6 {
+		t.Fatalf("expected a segment per batch of both writers, got %d", n)
+	}
+
+	srv.healthy.Store(true)
+	w := NewHTTPStreamWriter(srv.URL, nil, WithRetryPolicy(noRetry), WithSpool(dir, 1<<20, SpoolDropOldest))
+	received := srv.waitForLines(t, 6)
+	w.Close()
+	time.Sleep(50 * time.Millisecond)
+
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	seen := make(map[string]bool)
+	for _, line := range srv.received {
+		if seen[line] {
+			t.Errorf("line replayed twice: %s", line)
+		}
+		seen[line] = true
+	}
+	if len(received) != 6 || len(srv.received) != 6 {
+		t.Errorf("expected 6 lines, got %q", srv.received)
+	}
+}
+
+func TestSpoolReplaysOnNextStart(t *testing.T) {
+	srv := newFlakyServer(t)
+	dir := t.TempDir()
+
+	w := NewHTTPStreamWriter(srv.URL, nil, WithRetryPolicy(noRetry), WithSpool(dir, 1<<20, SpoolDropOldest))
+	io.WriteString(w, "{\"msg\":\"from the last run\"}\n")
+	w.Close()
+	if segmentCount(t, dir) != 1 {
+		t.Fatalf("expected one spooled segment, got %d", segmentCount(t, dir))
+	}
+
+	// A new writer, as created by the next process, replays without any writes
+	srv.healthy.Store(true)
+	NewHTTPStreamWriter(srv.URL, nil, WithRetryPolicy(noRetry), WithSpool(dir, 1<<20, SpoolDropOldest))
+
+	received := srv.waitForLines(t, 1)
+	if !strings.Contains(received[0], "from the last run") {
+		t.Errorf("unexpected replayed line %q", received[0])
+	}
+}
+
+func TestSpoolEviction(t *testing.T) {
+	testCases := []struct {
+		eviction SpoolEviction
+		want     []string
+	}{
+		{SpoolDropOldest, []string{"b\n", "c\n"}},
+		{SpoolDropNewest, []string{"a\n", "b\n"}},
+	}
+
+	for _, tc := range testCases {
+		sp, err := openSpool(t.TempDir(), 4, tc.eviction)
+		if err != nil {
+			t.Fatalf("failed to open spool: %v", err)
+		}
+		var dropped int
+		for _, body := range []string{"a\n", "b\n", "c\n"} {
+			n, _, err := sp.append([]byte(body))
+			if err != nil {
+				t.Fatalf("failed to append: %v", err)
+			}
+			dropped += n
+		}
+		if dropped != 1 {
+			t.Errorf("eviction %d: expected 1 dropped line, got %d", tc.eviction, dropped)
+		}
+
+		var got []string
+		for {
+			name, body, ok, err := sp.oldest()
+			if !ok || err != nil {
+				break
+			}
+			got = append(got, string(body))
+			sp.remove(name)
+		}
+		if strings.Join(got, "") != strings.Join(tc.want, "") {
+			t.Errorf("eviction %d: expected segments %q, got %q", tc.eviction, tc.want, got)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package gosh
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"sync/atomic"
+)
+
+// stdinSource opens the input of a run.
+type stdinSource func(ctx context.Context) (io.ReadCloser, error)
+
+// Stdin makes r the command's standard input. The input is copied to the
+// command concurrently with its output being read, so large inputs cannot
+// deadlock against full output pipes. An *os.File, such as os.Stdin, is
+// passed to the command directly instead and is not closed. A reader can
+// only be consumed once: a Shell that runs again gets whatever r has left.
+func (s *Shell) Stdin(r io.Reader) *Shell {
+	s.stdin = func(context.Context) (io.ReadCloser, error) {
+		if f, ok := r.(*os.File); ok {
+			return fileInput{f}, nil
+		}
+		return io.NopCloser(r), nil
+	}
+	return s
+}
+
+// StdinString makes input the command's standard input, on every run.
+func (s *Shell) StdinString(input string) *Shell {
+	s.stdin = func(context.Context) (io.ReadCloser, error) {
+		return io.NopCloser(strings.NewReader(input)), nil
+	}
+	return s
+}
+
+// StdinFile makes the contents of the file at path the command's standard
+// input. The file is opened when the command starts and passed to it
+// directly; if it cannot be opened the command is not started.
+func (s *Shell) StdinFile(path string) *Shell {
+	s.stdin = func(context.Context) (io.ReadCloser, error) {
+		return os.Open(path)
+	}
+	return s
+}
+
+// StdinURL makes the body of a GET request to url the command's standard
+// input. The request is made when the command starts and is cancelled with
+// its context; the body is streamed to the command as it arrives. If the
+// request fails or the response status is not 2xx, the command is not started.
+func (s *Shell) StdinURL(url string) *Shell {
+	s.stdin = func(ctx context.Context) (io.ReadCloser, error) {
+		req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			resp.Body.Close()
+			return nil, fmt.Errorf("GET %s: %s", url, resp.Status)
+		}
+		return resp.Body, nil
+	}
+	return s
+}
+
+// fileInput is an *os.File given to Stdin, which belongs to the caller and
+// so is not closed after the run.
+type fileInput struct {
+	*os.File
+}
+
+// Close implements io.Closer without closing the file.
+func (fileInput) Close() error {
+	return nil
+}
+
+// stdinCopy connects the input of a run to the standard input of the
+// command. An *os.File is passed to the command as is. Any other input is
+// copied into a pipe by a goroutine of ours rather than by exec.Cmd, whose
+// Wait would wait for a read from the input that may never return; ours is
+// abandoned once the command has finished.
+type stdinCopy struct {
+	file   *os.File // input passed to the command directly
+	offset int64    // offset of file before the run, or -1 if not seekable
+
+	input  countingReader
+	pr, pw *os.File // pipe the input is copied into
+}
+
+// connectStdin makes input the standard input of cmd.
+func connectStdin(cmd *exec.Cmd, input io.Reader) (*stdinCopy, error) {
+	if fi, ok := input.(fileInput); ok {
+		input = fi.File
+	}
+	if f, ok := input.(*os.File); ok {
+		offset, err := f.Seek(0, io.SeekCurrent)
+		if err != nil {
+			offset = -1
+		}
+		cmd.Stdin = f
+		return &stdinCopy{file: f, offset: offset}, nil
+	}
+
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	cmd.Stdin = pr
+	return &stdinCopy{input: countingReader{r: input}, pr: pr, pw: pw}, nil
+}
+
+// start begins copying the input once the command has inherited the read
+// end of the pipe. It is a no-op on a nil *stdinCopy.
+func (c *stdinCopy) start() {
+	if c == nil || c.pw == nil {
+		return
+	}
+	// Close our read end so the copy fails with EPIPE once the command exits
+	c.pr.Close()
+	go func() {
+		io.Copy(c.pw, &c.input)
+		c.pw.Close()
+	}()
+}
+
+// close stops feeding the command and returns the number of bytes read from
+// the input: those copied into the pipe, which may be more than the command
+// consumed, or how far the offset of a file advanced. The input of a file
+// that cannot seek, such as a pipe or terminal, is not counted. It returns
+// 0 on a nil *stdinCopy.
+func (c *stdinCopy) close() int64 {
+	if c == nil {
+		return 0
+	}
+	if c.file != nil {
+		if c.offset < 0 {
+			return 0
+		}
+		end, err := c.file.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return 0
+		}
+		return end - c.offset
+	}
+	c.pr.Close()
+	// Unblocks a copy stuck writing to a pipe held open by descendants; one
+	// stuck reading the input is left to finish or fail on its own.
+	c.pw.Close()
+	return c.input.n.Load()
+}
+
+// countingReader counts the bytes read through it. The count is atomic
+// because the goroutine copying the input may still be reading when the
+// run returns.
+type countingReader struct {
+	r io.Reader
+	n atomic.Int64
+}
+
+// Read implements io.Reader interface
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n.Add(int64(n))
+	return n, err
+}
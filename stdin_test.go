@@ -0,0 +1,198 @@
+package gosh
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStdinSources(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "input.txt")
+	if err := os.WriteFile(path, []byte("from file\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "from url")
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name  string
+		shell *Shell
+		want  string
+	}{
+		{"reader", New().Stdin(strings.NewReader("from reader\n")), "from reader"},
+		{"string", New().StdinString("from string\n"), "from string"},
+		{"file", New().StdinFile(path), "from file"},
+		{"url", New().StdinURL(server.URL), "from url"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var res *Result
+			var err error
+			captureOutput(func() {
+				res, err = tt.shell.Command("cat").ExecResult()
+			})
+			if err != nil {
+				t.Fatalf("expected command to succeed, but it failed: %v", err)
+			}
+			if got := strings.TrimSpace(string(res.Stdout)); got != tt.want {
+				t.Errorf("expected output %q, got %q", tt.want, got)
+			}
+			if res.StdinBytes != int64(len(tt.want)+1) {
+				t.Errorf("expected %d stdin bytes, got %d", len(tt.want)+1, res.StdinBytes)
+			}
+		})
+	}
+}
+
+func TestStdinStringReusable(t *testing.T) {
+	s := New().StdinString("again").Command("cat")
+	for i := 0; i < 2; i++ {
+		var out string
+		var err error
+		captureOutput(func() {
+			out, err = s.Exec()
+		})
+		if err != nil || out != "again" {
+			t.Fatalf("run %d: expected %q, got %q, %v", i, "again", out, err)
+		}
+	}
+}
+
+func TestStdinLargeInputStream(t *testing.T) {
+	// Far more than a pipe buffer, so the input and output must flow concurrently
+	var input strings.Builder
+	for i := 0; i < 100000; i++ {
+		fmt.Fprintf(&input, "line %d\n", i)
+	}
+	ring := NewRingBuffer(1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+	res, err := New().
+		WithSinkOnly(ring).
+		StdinString(input.String()).
+		Command("cat").
+		StreamResultContext(ctx)
+	if err != nil {
+		t.Fatalf("expected command to succeed, but it failed: %v", err)
+	}
+	if res.StdinBytes != int64(input.Len()) {
+		t.Errorf("expected %d stdin bytes, got %d", input.Len(), res.StdinBytes)
+	}
+	if lines := ring.Lines(); len(lines) != 1 || !strings.Contains(lines[0], "line 99999") {
+		t.Errorf("expected the last line to be logged, got %q", lines)
+	}
+}
+
+// blockingReader blocks every Read until unblock is closed.
+type blockingReader struct {
+	unblock chan struct{}
+}
+
+func (r blockingReader) Read(p []byte) (int, error) {
+	<-r.unblock
+	return 0, io.EOF
+}
+
+func TestStdinDoesNotOutliveCommand(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	defer w.Close()
+	unblock := make(chan struct{})
+	defer close(unblock)
+
+	tests := []struct {
+		name  string
+		shell *Shell
+	}{
+		{"open pipe", New().Stdin(r).Command("echo").Args("hi")},
+		{"blocking reader", New().Stdin(blockingReader{unblock}).Command("echo").Args("hi")},
+		{"timeout", New().Stdin(r).Command("head").Args("-c", "1").Timeout(300 * time.Millisecond)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var err error
+			returned := false
+			captureOutput(func() {
+				done := make(chan error, 1)
+				go func() {
+					_, err := tt.shell.WaitDelay(100 * time.Millisecond).Exec()
+					done <- err
+				}()
+				select {
+				case err = <-done:
+					returned = true
+				case <-time.After(5 * time.Second):
+				}
+			})
+			if !returned {
+				t.Fatal("expected Exec to return once the command finished")
+			}
+			if tt.name == "timeout" {
+				var timeoutErr *TimeoutError
+				if !errors.As(err, &timeoutErr) {
+					t.Errorf("expected a timeout, got %v", err)
+				}
+			} else if err != nil {
+				t.Errorf("expected command to succeed, but it failed: %v", err)
+			}
+		})
+	}
+}
+
+func TestStdinErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "gone", http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	var err error
+	captureOutput(func() {
+		_, err = New().StdinFile(filepath.Join(t.TempDir(), "missing")).Command("cat").Exec()
+	})
+	if !errors.Is(err, fs.ErrNotExist) || errors.Is(err, ErrNotFound) {
+		t.Errorf("expected a missing input file error, got %v", err)
+	}
+
+	captureOutput(func() {
+		_, err = New().StdinURL(server.URL).Command("cat").Exec()
+	})
+	if err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("expected a 404 error, got %v", err)
+	}
+}
+
+func TestLifecycleStdinBytes(t *testing.T) {
+	ConfigureGlobals()
+
+	logOutput := captureOutput(func() {
+		New().WithLifecycleEvents().StdinString("abc").Command("cat").Stream()
+	})
+	entries := parseLogLines(t, logOutput)
+	finished := entries[len(entries)-1]
+	if finished["event"] != EventCommandFinished || finished["stdin_bytes"] != float64(3) {
+		t.Errorf("expected stdin_bytes in finished event, got %v", finished)
+	}
+
+	logOutput = captureOutput(func() {
+		New().WithLifecycleEvents().Command("true").Stream()
+	})
+	entries = parseLogLines(t, logOutput)
+	if _, ok := entries[len(entries)-1]["stdin_bytes"]; ok {
+		t.Errorf("expected no stdin_bytes without stdin, got %v", entries[len(entries)-1])
+	}
+}
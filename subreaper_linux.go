@@ -0,0 +1,49 @@
+package gosh
+
+import (
+	"sync/atomic"
+	"syscall"
+)
+
+// prSetChildSubreaper is PR_SET_CHILD_SUBREAPER from <linux/prctl.h>.
+const prSetChildSubreaper = 36
+
+// subreaper reports whether EnableSubreaper has been called successfully.
+var subreaper atomic.Bool
+
+// EnableSubreaper marks the current process as a child subreaper, so that
+// descendants orphaned when a command's shell exits are re-parented to it
+// instead of to init. gosh then reaps the orphans of each command's process
+// group in the background, so they do not linger as zombies.
+// It affects the whole process and should be called once at startup.
+// It returns errors.ErrUnsupported on platforms other than Linux.
+func EnableSubreaper() error {
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, prSetChildSubreaper, 1, 0); errno != 0 {
+		return errno
+	}
+	subreaper.Store(true)
+	return nil
+}
+
+// reapOrphans waits in the background for the remaining members of the
+// process group pgid, which are our children once we are a subreaper and the
+// group leader has exited. It must only be called after the leader has been
+// waited for, so it never steals the leader's exit status from exec.Cmd.
+func reapOrphans(pgid int) {
+	if !subreaper.Load() {
+		return
+	}
+	go func() {
+		for {
+			var status syscall.WaitStatus
+			_, err := syscall.Wait4(-pgid, &status, 0, nil)
+			if err == syscall.EINTR {
+				continue
+			}
+			if err != nil {
+				// ECHILD: no members of the group are left to reap
+				return
+			}
+		}
+	}()
+}
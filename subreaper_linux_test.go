@@ -0,0 +1,45 @@
+package gosh
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestSubreaperReapsOrphans(t *testing.T) {
+	ConfigureGlobals()
+
+	if err := EnableSubreaper(); err != nil {
+		t.Skipf("cannot become a child subreaper: %v", err)
+	}
+
+	pidFile := filepath.Join(t.TempDir(), "pid")
+
+	var err error
+	captureOutput(func() {
+		// The background sleep closes the output pipes, so the shell exits
+		// immediately and the sleep is orphaned to us.
+		_, err = New().
+			Command("sh").
+			Args("-c", "sleep 0.2 >/dev/null 2>&1 & echo $! > "+pidFile).
+			Exec()
+	})
+	if err != nil {
+		t.Fatalf("expected command to succeed, but it failed: %v", err)
+	}
+
+	pid := readPID(t, pidFile)
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		// Unlike processGone, a zombie still has a /proc entry here
+		if _, err := os.Stat(filepath.Join("/proc", strconv.Itoa(pid))); os.IsNotExist(err) {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected orphan %d to be reaped", pid)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+
+package gosh
+
+import "errors"
+
+// EnableSubreaper marks the current process as a child subreaper. It is only
+// supported on Linux and returns errors.ErrUnsupported elsewhere.
+func EnableSubreaper() error {
+	return errors.ErrUnsupported
+}
+
+// reapOrphans is a no-op on platforms without child subreapers.
+func reapOrphans(pgid int) {}
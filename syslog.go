@@ -0,0 +1,123 @@
+package gosh
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// Syslog facilities commonly used for application logs.
+const (
+	SyslogFacilityUser   = 1
+	SyslogFacilityLocal0 = 16
+)
+
+// rfc5424Time is the TIMESTAMP format of RFC 5424 with microseconds.
+const rfc5424Time = "2006-01-02T15:04:05.000000Z07:00"
+
+// SyslogSink is a Sink that sends each log line as an RFC 5424 syslog
+// message over UDP or TCP. The severity is taken from the line's zerolog
+// level and the JSON line itself is the message, so log KVs survive. Over
+// TCP messages are framed by octet counting (RFC 6587). Like SocketSink, it
+// reconnects and retries once when a send fails, and then drops lines
+// without reconnecting for a backoff; over UDP lost messages go unnoticed.
+type SyslogSink struct {
+	facility int
+	hostname string
+	appName  string
+	framed   bool
+
+	mutex sync.Mutex
+	conn  reconnectingConn
+}
+
+// NewSyslogSink returns a SyslogSink sending to address over network
+// ("udp" or "tcp"), with the given facility (0-23, such as
+// SyslogFacilityLocal0) and APP-NAME.
+func NewSyslogSink(network, address string, facility int, appName string) *SyslogSink {
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "-"
+	}
+	if appName == "" {
+		appName = "-"
+	}
+	return &SyslogSink{
+		facility: facility,
+		hostname: hostname,
+		appName:  appName,
+		framed:   strings.HasPrefix(network, "tcp"),
+		conn:     reconnectingConn{network: network, address: address},
+	}
+}
+
+// Write implements io.Writer interface. Every line in p becomes a message.
+func (s *SyslogSink) Write(p []byte) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := time.Now()
+	for _, line := range bytes.Split(p, []byte{'\n'}) {
+		line = bytes.TrimSuffix(line, []byte{'\r'})
+		if len(line) == 0 {
+			continue
+		}
+		if err := s.conn.write(s.format(line, now)); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// Flush implements Sink. Messages are not buffered, so there is nothing to do.
+func (s *SyslogSink) Flush() error {
+	return nil
+}
+
+// Close closes the connection. The next write reconnects, unless a
+// reconnect backoff is in progress.
+func (s *SyslogSink) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.conn.close()
+}
+
+// format builds the RFC 5424 message for line, with octet-counting framing
+// over TCP.
+func (s *SyslogSink) format(line []byte, now time.Time) []byte {
+	msg := fmt.Sprintf("<%d>1 %s %s %s %d - - %s",
+		s.facility*8+syslogSeverity(line), now.Format(rfc5424Time), s.hostname, s.appName, os.Getpid(), line)
+	if s.framed {
+		msg = fmt.Sprintf("%d %s", len(msg), msg)
+	}
+	return []byte(msg)
+}
+
+// syslogSeverity maps the zerolog level of a JSON line to a syslog severity,
+// defaulting to informational.
+func syslogSeverity(line []byte) int {
+	var fields map[string]json.RawMessage
+	var level string
+	if json.Unmarshal(line, &fields) == nil {
+		json.Unmarshal(fields[zerolog.LevelFieldName], &level)
+	}
+	switch level {
+	case zerolog.LevelPanicValue:
+		return 1 // alert
+	case zerolog.LevelFatalValue:
+		return 2 // critical
+	case zerolog.LevelErrorValue:
+		return 3 // error
+	case zerolog.LevelWarnValue:
+		return 4 // warning
+	case zerolog.LevelDebugValue, zerolog.LevelTraceValue:
+		return 7 // debug
+	}
+	return 6 // informational
+}
@@ -0,0 +1,98 @@
+package gosh
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfc5424Pattern matches the messages of a SyslogSink, capturing PRI,
+// APP-NAME and MSG.
+var rfc5424Pattern = regexp.MustCompile(`^<(\d+)>1 \d{4}-\d\d-\d\dT\d\d:\d\d:\d\d\.\d{6}(?:Z|[+-]\d\d:\d\d) \S+ (\S+) \d+ - - (.*)$`)
+
+func TestSyslogSinkUDP(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer conn.Close()
+
+	s := NewSyslogSink("udp", conn.LocalAddr().String(), SyslogFacilityLocal0, "builder")
+	defer s.Close()
+	s.Write([]byte("{\"level\":\"error\",\"msg\":\"boom\"}\n{\"level\":\"info\",\"msg\":\"ok\"}\n"))
+
+	tests := []struct {
+		pri string
+		msg string
+	}{
+		{"131", `{"level":"error","msg":"boom"}`}, // local0.err
+		{"134", `{"level":"info","msg":"ok"}`},    // local0.info
+	}
+	buf := make([]byte, 2048)
+	for _, tt := range tests {
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			t.Fatalf("failed to read datagram: %v", err)
+		}
+		m := rfc5424Pattern.FindStringSubmatch(string(buf[:n]))
+		if m == nil {
+			t.Fatalf("expected an RFC 5424 message, got %q", buf[:n])
+		}
+		if m[1] != tt.pri || m[2] != "builder" || m[3] != tt.msg {
+			t.Errorf("expected PRI %s and message %q, got %q", tt.pri, tt.msg, buf[:n])
+		}
+	}
+}
+
+func TestSyslogSinkTCPOctetCounting(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer l.Close()
+
+	frames := make(chan string, 2)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		for {
+			length, err := r.ReadString(' ')
+			if err != nil {
+				return
+			}
+			n, _ := strconv.Atoi(strings.TrimSpace(length))
+			frame := make([]byte, n)
+			if _, err := io.ReadFull(r, frame); err != nil {
+				return
+			}
+			frames <- string(frame)
+		}
+	}()
+
+	s := NewSyslogSink("tcp", l.Addr().String(), SyslogFacilityUser, "")
+	defer s.Close()
+	s.Write([]byte("{\"level\":\"warn\",\"msg\":\"first\"}\n"))
+	s.Write([]byte("{\"level\":\"debug\",\"msg\":\"second\"}\n"))
+
+	for _, want := range []string{"<12>", "<15>"} { // user.warning, user.debug
+		select {
+		case frame := <-frames:
+			m := rfc5424Pattern.FindStringSubmatch(frame)
+			if m == nil || "<"+m[1]+">" != want || m[2] != "-" {
+				t.Errorf("expected a framed message with PRI %s, got %q", want, frame)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("timed out waiting for a frame")
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package gosh
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+)
+
+// TLSOptions describes the TLS settings of the HTTP stream client.
+// Zero values keep Go's defaults.
+type TLSOptions struct {
+	// CertFile and KeyFile hold a PEM client certificate and key, presented
+	// to servers that require mutual TLS.
+	CertFile string
+	KeyFile  string
+	// CAFile holds PEM CA certificates trusted to sign the server's
+	// certificate, instead of the system roots.
+	CAFile string
+	// ServerName overrides the name the server certificate is verified against.
+	ServerName string
+	// MinVersion is the minimum TLS version, such as tls.VersionTLS13.
+	MinVersion uint16
+}
+
+// NewTLSConfig builds a *tls.Config from opts for use with WithTLSConfig.
+func NewTLSConfig(opts TLSOptions) (*tls.Config, error) {
+	cfg := &tls.Config{
+		ServerName: opts.ServerName,
+		MinVersion: opts.MinVersion,
+	}
+	if cfg.MinVersion == 0 {
+		cfg.MinVersion = tls.VersionTLS12
+	}
+
+	if opts.CertFile != "" || opts.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(opts.CertFile, opts.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("loading client certificate: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+
+	if opts.CAFile != "" {
+		pem, err := os.ReadFile(opts.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading CA bundle: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA bundle %s", opts.CAFile)
+		}
+		cfg.RootCAs = pool
+	}
+	return cfg, nil
+}
+
+// WithTLSConfig makes the writer use cfg for HTTPS connections, for example
+// one built with NewTLSConfig for mutual TLS.
+func WithTLSConfig(cfg *tls.Config) HTTPStreamOption {
+	return func(w *HTTPStreamWriter) {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = cfg
+		w.withTransport(transport)
+	}
+}
+
+// WithTransport makes the writer send requests through rt, keeping the
+// default client timeout.
+func WithTransport(rt http.RoundTripper) HTTPStreamOption {
+	return func(w *HTTPStreamWriter) {
+		w.withTransport(rt)
+	}
+}
+
+// WithHTTPClient makes the writer send requests with client, replacing the
+// default client and its timeout entirely.
+func WithHTTPClient(client *http.Client) HTTPStreamOption {
+	return func(w *HTTPStreamWriter) {
+		w.client = client
+	}
+}
+
+// withTransport replaces the transport of a copy of the writer's client, so
+// a client passed to WithHTTPClient is never modified.
+func (w *HTTPStreamWriter) withTransport(rt http.RoundTripper) {
+	client := *w.client
+	client.Transport = rt
+	w.client = &client
+}
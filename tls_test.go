@@ -0,0 +1,165 @@
+package gosh
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testCert is a generated certificate and key, written as PEM files.
+type testCert struct {
+	cert     *x509.Certificate
+	key      *ecdsa.PrivateKey
+	certFile string
+	keyFile  string
+}
+
+// newTestCert generates a certificate signed by parent, or a self-signed CA
+// if parent is nil, and writes it to dir.
+func newTestCert(t *testing.T, dir, name string, parent *testCert, usage x509.ExtKeyUsage) *testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+	signer, signerKey := template, key
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage = x509.KeyUsageCertSign
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+
+	tc := &testCert{
+		cert:     cert,
+		key:      key,
+		certFile: filepath.Join(dir, name+".pem"),
+		keyFile:  filepath.Join(dir, name+"-key.pem"),
+	}
+	os.WriteFile(tc.certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600)
+	os.WriteFile(tc.keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600)
+	return tc
+}
+
+func TestHTTPStreamWriterMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCert(t, dir, "ca", nil, x509.ExtKeyUsageAny)
+	serverCert := newTestCert(t, dir, "server", ca, x509.ExtKeyUsageServerAuth)
+	clientCert := newTestCert(t, dir, "client", ca, x509.ExtKeyUsageClientAuth)
+
+	var clientName atomic.Value
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		clientName.Store(r.TLS.PeerCertificates[0].Subject.CommonName)
+		io.Copy(io.Discard, r.Body)
+	}))
+	pair, err := tls.LoadX509KeyPair(serverCert.certFile, serverCert.keyFile)
+	if err != nil {
+		t.Fatalf("failed to load server certificate: %v", err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	srv.TLS = &tls.Config{
+		Certificates: []tls.Certificate{pair},
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	}
+	srv.StartTLS()
+	defer srv.Close()
+
+	cfg, err := NewTLSConfig(TLSOptions{
+		CertFile:   clientCert.certFile,
+		KeyFile:    clientCert.keyFile,
+		CAFile:     ca.certFile,
+		ServerName: "localhost",
+		MinVersion: tls.VersionTLS13,
+	})
+	if err != nil {
+		t.Fatalf("failed to build TLS config: %v", err)
+	}
+
+	w := NewHTTPStreamWriter(srv.URL, nil, WithTLSConfig(cfg))
+	io.WriteString(w, "{\"msg\":\"over mTLS\"}\n")
+	if err := w.Close(); err != nil {
+		t.Fatalf("expected delivery over mTLS to succeed, got %v", err)
+	}
+	if name, _ := clientName.Load().(string); name != "client" {
+		t.Errorf("expected the client certificate to be presented, got %q", name)
+	}
+
+	// Without a client certificate the handshake is rejected
+	cfg, _ = NewTLSConfig(TLSOptions{CAFile: ca.certFile})
+	w = NewHTTPStreamWriter(srv.URL, nil, WithTLSConfig(cfg), WithRetryPolicy(noRetry))
+	io.WriteString(w, "{\"msg\":\"anonymous\"}\n")
+	var deliveryErr *DeliveryError
+	if err := w.Close(); !errors.As(err, &deliveryErr) {
+		t.Errorf("expected delivery without a client certificate to fail, got %v", err)
+	}
+}
+
+func TestNewTLSConfigErrors(t *testing.T) {
+	dir := t.TempDir()
+	notPEM := filepath.Join(dir, "not.pem")
+	os.WriteFile(notPEM, []byte("nope"), 0o600)
+
+	if _, err := NewTLSConfig(TLSOptions{CAFile: notPEM}); err == nil {
+		t.Error("expected an error for a CA bundle without certificates")
+	}
+	if _, err := NewTLSConfig(TLSOptions{CertFile: notPEM, KeyFile: notPEM}); err == nil {
+		t.Error("expected an error for an invalid client certificate")
+	}
+}
+
+// countingTransport counts requests before passing them on.
+type countingTransport struct {
+	n atomic.Int32
+}
+
+func (c *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	c.n.Add(1)
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestHTTPStreamWriterCustomTransport(t *testing.T) {
+	srv := newRecordingServer(t)
+
+	transport := &countingTransport{}
+	w := NewHTTPStreamWriter(srv.URL, nil, WithTransport(transport))
+	io.WriteString(w, "{\"msg\":\"one\"}\n")
+	w.Close()
+
+	if transport.n.Load() != 1 {
+		t.Errorf("expected the request to go through the custom transport, got %d", transport.n.Load())
+	}
+}
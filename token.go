@@ -0,0 +1,215 @@
+package gosh
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// tokenExpiryDelta is how long before its expiry a cached token is refreshed,
+// so that it does not expire while a request is in flight.
+const tokenExpiryDelta = 10 * time.Second
+
+// Token is a credential sent in the Authorization header of every request.
+type Token struct {
+	// Value is the credential itself, such as an access token.
+	Value string
+	// Type is the authorization scheme; "Bearer" if empty.
+	Type string
+	// Expiry is when the token stops being valid. A token without an expiry
+	// is not cached: the provider is asked again before every batch.
+	Expiry time.Time
+}
+
+// header returns the Authorization header value for the token.
+func (t Token) header() string {
+	typ := t.Type
+	if typ == "" || strings.EqualFold(typ, "bearer") {
+		typ = "Bearer"
+	}
+	return typ + " " + t.Value
+}
+
+// TokenProvider supplies the credentials of an HTTPStreamWriter. It is
+// called before a batch is sent whenever no unexpired token is cached, and
+// again when the endpoint rejects a token with 401 Unauthorized. It may be
+// called from the sender and the spool replayer concurrently.
+type TokenProvider interface {
+	Token(ctx context.Context) (Token, error)
+}
+
+// TokenProviderFunc adapts a function to the TokenProvider interface.
+type TokenProviderFunc func(ctx context.Context) (Token, error)
+
+// Token calls f.
+func (f TokenProviderFunc) Token(ctx context.Context) (Token, error) {
+	return f(ctx)
+}
+
+// WithTokenProvider authorizes every request with a token from p, replacing
+// any Authorization header added with AddHTTPHeader. Tokens are cached until
+// shortly before their expiry; a request rejected with 401 Unauthorized is
+// retried once with a fresh token.
+func WithTokenProvider(p TokenProvider) HTTPStreamOption {
+	return func(w *HTTPStreamWriter) {
+		w.tokens = &tokenCache{provider: p}
+	}
+}
+
+// tokenCache caches the token of a TokenProvider until it expires.
+type tokenCache struct {
+	provider TokenProvider
+
+	mutex  sync.Mutex
+	token  Token
+	cached bool
+}
+
+// get returns the cached token, or a new one from the provider if there is
+// none, it is about to expire or refresh is set.
+func (c *tokenCache) get(ctx context.Context, refresh bool) (Token, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.cached && !refresh && time.Now().Add(tokenExpiryDelta).Before(c.token.Expiry) {
+		return c.token, nil
+	}
+	token, err := c.provider.Token(ctx)
+	if err != nil {
+		c.cached = false
+		return Token{}, err
+	}
+	c.token = token
+	c.cached = !token.Expiry.IsZero()
+	return token, nil
+}
+
+// StaticToken returns a provider that always supplies the same bearer token.
+func StaticToken(value string) TokenProvider {
+	return TokenProviderFunc(func(context.Context) (Token, error) {
+		return Token{Value: value}, nil
+	})
+}
+
+// TokenFile returns a provider that reads a bearer token from the file at
+// path, such as a projected service account token, and reads it again
+// whenever the file's modification time or size changes. Surrounding
+// whitespace is trimmed.
+func TokenFile(path string) TokenProvider {
+	return &tokenFile{path: path}
+}
+
+type tokenFile struct {
+	path string
+
+	mutex   sync.Mutex
+	modTime time.Time
+	size    int64
+	token   Token
+}
+
+// Token implements TokenProvider.
+func (f *tokenFile) Token(context.Context) (Token, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return Token{}, fmt.Errorf("reading token file: %w", err)
+	}
+	if f.token.Value != "" && info.ModTime().Equal(f.modTime) && info.Size() == f.size {
+		return f.token, nil
+	}
+
+	data, err := os.ReadFile(f.path)
+	if err != nil {
+		return Token{}, fmt.Errorf("reading token file: %w", err)
+	}
+	value := strings.TrimSpace(string(data))
+	if value == "" {
+		return Token{}, fmt.Errorf("token file %s is empty", f.path)
+	}
+	f.token = Token{Value: value}
+	f.modTime = info.ModTime()
+	f.size = info.Size()
+	return f.token, nil
+}
+
+// ClientCredentials is a TokenProvider that obtains access tokens from an
+// OAuth2 token endpoint with the client credentials grant (RFC 6749,
+// section 4.4). The client authenticates with HTTP Basic authentication.
+type ClientCredentials struct {
+	TokenURL     string
+	ClientID     string
+	ClientSecret string
+	Scopes       []string
+	// Client sends the token requests; a client with a 30 second timeout
+	// is used if nil.
+	Client *http.Client
+}
+
+// defaultTokenClient sends token requests for ClientCredentials without a Client.
+var defaultTokenClient = &http.Client{Timeout: 30 * time.Second}
+
+// Token implements TokenProvider by requesting a new access token.
+func (c *ClientCredentials) Token(ctx context.Context) (Token, error) {
+	form := url.Values{"grant_type": {"client_credentials"}}
+	if len(c.Scopes) > 0 {
+		form.Set("scope", strings.Join(c.Scopes, " "))
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", c.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return Token{}, fmt.Errorf("creating token request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(c.ClientID), url.QueryEscape(c.ClientSecret))
+
+	client := c.Client
+	if client == nil {
+		client = defaultTokenClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return Token{}, fmt.Errorf("requesting token: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return Token{}, fmt.Errorf("reading token response: %w", err)
+	}
+
+	if resp.StatusCode >= 400 {
+		statusErr := &HTTPStatusError{StatusCode: resp.StatusCode, Status: resp.Status}
+		if msg := bytes.TrimSpace(body); len(msg) > 0 {
+			return Token{}, fmt.Errorf("requesting token: %w: %s", statusErr, msg)
+		}
+		return Token{}, fmt.Errorf("requesting token: %w", statusErr)
+	}
+
+	var tr struct {
+		AccessToken string `json:"access_token"`
+		TokenType   string `json:"token_type"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err := json.Unmarshal(body, &tr); err != nil {
+		return Token{}, fmt.Errorf("decoding token response: %w", err)
+	}
+	if tr.AccessToken == "" {
+		return Token{}, fmt.Errorf("token response has no access_token")
+	}
+
+	token := Token{Value: tr.AccessToken, Type: tr.TokenType}
+	if tr.ExpiresIn > 0 {
+		token.Expiry = time.Now().Add(time.Duration(tr.ExpiresIn) * time.Second)
+	}
+	return token, nil
+}
@@ -0,0 +1,178 @@
+package gosh
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// authServer records the Authorization header of every request and rejects
+// those without one of the accepted values.
+type authServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	accepted map[string]bool
+	seen     []string
+}
+
+func newAuthServer(t *testing.T, accepted ...string) *authServer {
+	t.Helper()
+	as := &authServer{accepted: map[string]bool{}}
+	for _, a := range accepted {
+		as.accepted[a] = true
+	}
+	as.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		auth := r.Header.Get("Authorization")
+		as.mu.Lock()
+		defer as.mu.Unlock()
+		as.seen = append(as.seen, auth)
+		if !as.accepted[auth] {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	t.Cleanup(as.Close)
+	return as
+}
+
+func (as *authServer) headers() []string {
+	as.mu.Lock()
+	defer as.mu.Unlock()
+	return append([]string(nil), as.seen...)
+}
+
+// newTokenEndpoint starts an OAuth2 token endpoint that issues token-1,
+// token-2, ... to client "id" with secret "secret".
+func newTokenEndpoint(t *testing.T, expiresIn int) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var issued atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, secret, _ := r.BasicAuth()
+		if id != "id" || secret != "secret" || r.FormValue("grant_type") != "client_credentials" {
+			http.Error(w, `{"error":"invalid_client"}`, http.StatusUnauthorized)
+			return
+		}
+		if r.FormValue("scope") != "logs:write" {
+			http.Error(w, `{"error":"invalid_scope"}`, http.StatusBadRequest)
+			return
+		}
+		n := issued.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"access_token":"token-%d","token_type":"bearer","expires_in":%d}`, n, expiresIn)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &issued
+}
+
+func TestStaticToken(t *testing.T) {
+	srv := newAuthServer(t, "Bearer s3cr3t")
+
+	w := NewHTTPStreamWriter(srv.URL, http.Header{"Authorization": {"Bearer stale"}}, WithTokenProvider(StaticToken("s3cr3t")))
+	io.WriteString(w, "{\"msg\":\"one\"}\n")
+	if err := w.Close(); err != nil {
+		t.Fatalf("expected delivery to succeed, got %v", err)
+	}
+}
+
+func TestTokenFileReloadedOnChange(t *testing.T) {
+	srv := newAuthServer(t, "Bearer first", "Bearer second")
+	path := filepath.Join(t.TempDir(), "token")
+	os.WriteFile(path, []byte("first\n"), 0o600)
+
+	w := NewHTTPStreamWriter(srv.URL, nil, WithTokenProvider(TokenFile(path)))
+	io.WriteString(w, "{\"msg\":\"one\"}\n")
+	w.Flush()
+
+	os.WriteFile(path, []byte("second\n"), 0o600)
+	os.Chtimes(path, time.Now(), time.Now().Add(time.Minute))
+	io.WriteString(w, "{\"msg\":\"two\"}\n")
+	if err := w.Close(); err != nil {
+		t.Fatalf("expected delivery to succeed, got %v", err)
+	}
+
+	want := []string{"Bearer first", "Bearer second"}
+	if got := srv.headers(); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("expected Authorization headers %q, got %q", want, got)
+	}
+}
+
+func TestClientCredentialsCachedUntilExpiry(t *testing.T) {
+	tokenSrv, issued := newTokenEndpoint(t, 3600)
+	srv := newAuthServer(t, "Bearer token-1")
+
+	w := NewHTTPStreamWriter(srv.URL, nil, WithTokenProvider(&ClientCredentials{
+		TokenURL:     tokenSrv.URL,
+		ClientID:     "id",
+		ClientSecret: "secret",
+		Scopes:       []string{"logs:write"},
+	}))
+	for i := 0; i < 3; i++ {
+		fmt.Fprintf(w, "{\"msg\":\"batch %d\"}\n", i)
+		w.Flush()
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("expected delivery to succeed, got %v", err)
+	}
+
+	if issued.Load() != 1 {
+		t.Errorf("expected the token to be requested once, got %d requests", issued.Load())
+	}
+	if n := len(srv.headers()); n != 3 {
+		t.Errorf("expected 3 batches, got %d", n)
+	}
+}
+
+func TestClientCredentialsRefreshedOnUnauthorized(t *testing.T) {
+	tokenSrv, issued := newTokenEndpoint(t, 3600)
+	// The first token was revoked before its expiry
+	srv := newAuthServer(t, "Bearer token-2")
+
+	w := NewHTTPStreamWriter(srv.URL, nil, WithRetryPolicy(noRetry), WithTokenProvider(&ClientCredentials{
+		TokenURL:     tokenSrv.URL,
+		ClientID:     "id",
+		ClientSecret: "secret",
+		Scopes:       []string{"logs:write"},
+	}))
+	io.WriteString(w, "{\"msg\":\"one\"}\n")
+	if err := w.Close(); err != nil {
+		t.Fatalf("expected delivery to succeed after refreshing the token, got %v", err)
+	}
+
+	want := []string{"Bearer token-1", "Bearer token-2"}
+	if got := srv.headers(); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("expected Authorization headers %q, got %q", want, got)
+	}
+	if issued.Load() != 2 {
+		t.Errorf("expected 2 token requests, got %d", issued.Load())
+	}
+}
+
+func TestClientCredentialsRejected(t *testing.T) {
+	tokenSrv, _ := newTokenEndpoint(t, 3600)
+	srv := newAuthServer(t)
+
+	w := NewHTTPStreamWriter(srv.URL, nil, WithTokenProvider(&ClientCredentials{
+		TokenURL:     tokenSrv.URL,
+		ClientID:     "id",
+		ClientSecret: "wrong",
+	}))
+	io.WriteString(w, "{\"msg\":\"one\"}\n")
+	err := w.Close()
+
+	if err == nil {
+		t.Fatal("expected delivery to fail with invalid client credentials")
+	}
+	if len(srv.headers()) != 0 {
+		t.Errorf("expected no request without a token, got %q", srv.headers())
+	}
+	if stats := w.Stats(); stats.Retried != 0 {
+		t.Errorf("expected a rejected token request not to be retried, got %d retries", stats.Retried)
+	}
+}
@@ -0,0 +1,131 @@
+package gosh
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// WebhookOptions describes the requests of a webhook sink.
+type WebhookOptions struct {
+	// Template is a text/template that renders the request body for a batch
+	// of lines from a WebhookBatch. Besides the built-in functions, "json"
+	// renders a value as JSON, including quoting and escaping strings.
+	Template string
+	// ContentType is the Content-Type of the body; "application/json" if empty.
+	ContentType string
+	// LogKVs are log KVs for a sink that is written to directly rather than
+	// through a Shell. The KVs of a Shell writing to the sink are added to them.
+	LogKVs map[string]string
+}
+
+// WebhookBatch is the data a webhook template is executed with.
+type WebhookBatch struct {
+	// Lines are the lines of the batch, in order.
+	Lines []WebhookLine
+	// LogKVs holds the KVs set with LogKV on the Shell writing to the sink,
+	// and those given in WebhookOptions.
+	LogKVs map[string]string
+}
+
+// WebhookLine is a log line in a WebhookBatch.
+type WebhookLine struct {
+	// Raw is the line as written, without its newline.
+	Raw string
+	// Fields are the fields of a JSON line, or nil for other lines.
+	Fields map[string]any
+	// Message, Level and Time are taken from the zerolog fields of a JSON
+	// line. For other lines, Message is the whole line and Time is when
+	// the batch was rendered.
+	Message string
+	Level   string
+	Time    time.Time
+}
+
+// webhookFuncs are the functions available to webhook templates.
+var webhookFuncs = template.FuncMap{
+	"json": func(v any) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+// NewWebhookSink returns an HTTPStreamWriter that posts each batch of log
+// lines to url with a body rendered from opts.Template, with the usual
+// batching, retries and spooling; streamOpts tune them. It returns an error
+// if the template does not parse. A batch the template fails to render is
+// counted as failed and reported, without retries. For example, a chat
+// webhook could use:
+//
+//	{"text": {{json (printf "Deploy %s" .LogKVs.deploymentId)}},
+//	 "lines": [{{range $i, $l := .Lines}}{{if $i}},{{end}}{{json $l.Message}}{{end}}]}
+func NewWebhookSink(url string, opts WebhookOptions, streamOpts ...HTTPStreamOption) (*HTTPStreamWriter, error) {
+	tmpl, err := template.New("webhook").Funcs(webhookFuncs).Parse(opts.Template)
+	if err != nil {
+		return nil, fmt.Errorf("parsing webhook template: %w", err)
+	}
+	enc := &webhookEncoder{tmpl: tmpl, contentType: opts.ContentType, staticKVs: opts.LogKVs}
+	if enc.contentType == "" {
+		enc.contentType = "application/json"
+	}
+	return NewHTTPStreamWriter(url, nil, append([]HTTPStreamOption{WithBatchEncoder(enc)}, streamOpts...)...), nil
+}
+
+// webhookEncoder is the BatchEncoder of NewWebhookSink.
+type webhookEncoder struct {
+	tmpl        *template.Template
+	contentType string
+	staticKVs   map[string]string
+
+	mutex    sync.Mutex
+	shellKVs map[string]string // set by the Shell writing to the sink
+}
+
+// setLogKVs implements logKVsSink.
+func (e *webhookEncoder) setLogKVs(kvs map[string]string) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.shellKVs = kvs
+}
+
+// Encode implements BatchEncoder.
+func (e *webhookEncoder) Encode(batch []byte) ([]byte, string, error) {
+	kvs := maps.Clone(e.staticKVs)
+	if kvs == nil {
+		kvs = make(map[string]string)
+	}
+	e.mutex.Lock()
+	maps.Copy(kvs, e.shellKVs)
+	e.mutex.Unlock()
+
+	var body bytes.Buffer
+	if err := e.tmpl.Execute(&body, newWebhookBatch(batch, kvs)); err != nil {
+		return nil, "", err
+	}
+	return body.Bytes(), e.contentType, nil
+}
+
+// newWebhookBatch builds the template data for a batch.
+func newWebhookBatch(batch []byte, kvs map[string]string) WebhookBatch {
+	now := time.Now()
+	data := WebhookBatch{LogKVs: kvs}
+	for _, raw := range batchLines(batch) {
+		line := WebhookLine{Raw: string(raw), Message: string(raw), Time: now}
+		if fields, ok := parseRecord(raw); ok {
+			line.Fields = fields
+			line.Message, _ = fields[zerolog.MessageFieldName].(string)
+			line.Level, _ = fields[zerolog.LevelFieldName].(string)
+			if t, ok := recordTime(fields); ok {
+				line.Time = t
+			}
+		}
+		data.Lines = append(data.Lines, line)
+	}
+	return data
+}
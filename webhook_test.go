@@ -0,0 +1,104 @@
+package gosh
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestWebhookSink(t *testing.T) {
+	ConfigureGlobals()
+
+	var mu sync.Mutex
+	var bodies []map[string]any
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]any
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("expected the rendered body to be JSON: %v", err)
+		}
+		mu.Lock()
+		bodies = append(bodies, body)
+		mu.Unlock()
+	}))
+	defer srv.Close()
+
+	sink, err := NewWebhookSink(srv.URL, WebhookOptions{
+		Template: `{"text": {{json (printf "Deploy %s" .LogKVs.deploymentId)}}, "lines": [{{range $i, $l := .Lines}}{{if $i}},{{end}}{{json (printf "%s: %s" $l.Level $l.Message)}}{{end}}]}`,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = New().
+		WithSinkOnly(sink).
+		LogKV("deploymentId", `d"1`).
+		Command("sh").
+		Args("-c", `echo 'quote " and \ backslash'`).
+		Stream()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(bodies) != 1 {
+		t.Fatalf("expected 1 request, got %d", len(bodies))
+	}
+	if bodies[0]["text"] != `Deploy d"1` {
+		t.Errorf("expected the log KVs in the body, got %v", bodies[0]["text"])
+	}
+	lines, _ := bodies[0]["lines"].([]any)
+	if len(lines) != 1 || lines[0] != `info: quote " and \ backslash` {
+		t.Errorf("expected the line fields in the body, got %v", bodies[0]["lines"])
+	}
+}
+
+func TestWebhookSinkTemplateErrors(t *testing.T) {
+	if _, err := NewWebhookSink("http://localhost", WebhookOptions{Template: "{{.Lines"}); err == nil {
+		t.Error("expected an error for a template that does not parse")
+	}
+
+	srv := newRecordingServer(t)
+	sink, err := NewWebhookSink(srv.URL, WebhookOptions{Template: `{{(index .Lines 5).Raw}}`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(sink, "{\"msg\":\"one\"}\n")
+	closeErr := sink.Close()
+
+	if !errors.Is(closeErr, ErrEncoding) {
+		t.Errorf("expected a rendering failure to be reported as ErrEncoding, got %v", closeErr)
+	}
+	if stats := sink.Stats(); stats.Failed != 1 || stats.Retried != 0 {
+		t.Errorf("expected the batch to fail without retries, got %+v", stats)
+	}
+	if srv.requestCount() != 0 {
+		t.Errorf("expected nothing to be sent, got %d requests", srv.requestCount())
+	}
+}
+
+func TestWebhookLogKVs(t *testing.T) {
+	sink, err := NewWebhookSink("http://localhost", WebhookOptions{
+		Template: `{{range $k, $v := .LogKVs}}{{$k}}={{$v}} {{end}}|{{(index .Lines 1).Message}}`,
+		LogKVs:   map[string]string{"env": "prod", "deploymentId": "d0"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sinks := &sinkWriter{sinks: []Sink{sink}}
+	sinks.setLogKVs(map[string]string{"deploymentId": "d1"})
+
+	body, _, err := sink.encoder.Encode([]byte(
+		"{\"level\":\"info\",\"deploymentId\":\"d1\",\"step\":\"1\",\"msg\":\"a\"}\n" +
+			"not json\n" +
+			"{\"level\":\"info\",\"deploymentId\":\"d1\",\"step\":\"1\",\"msg\":\"b\"}\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(body), "deploymentId=d1 env=prod |not json"; got != want {
+		t.Errorf("expected only the configured KVs, with the Shell's taking precedence, got %q, want %q", got, want)
+	}
+}